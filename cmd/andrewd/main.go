@@ -0,0 +1,205 @@
+package main
+
+import (
+	"andrew_chat/intenal/andrewd"
+	"andrew_chat/intenal/domain"
+	"andrew_chat/intenal/ratelimit"
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// rooms served when no rooms file is given
+var defaultRooms = []andrewd.RoomConfig{
+	{Name: "general", Group: true},
+}
+
+func loadRooms(path string) []andrewd.RoomConfig {
+	if path == "" {
+		return defaultRooms
+	}
+
+	var rooms []andrewd.RoomConfig
+	loadJSON("rooms", path, &rooms)
+	return rooms
+}
+
+func loadUsers(path string) []andrewd.UserConfig {
+	if path == "" {
+		return nil
+	}
+	var users []andrewd.UserConfig
+	loadJSON("users", path, &users)
+	return users
+}
+
+// loadJSON reads the file at path into v. A misspelled field, or a plain
+// text "password" of older files, must not leave a room open.
+func loadJSON(what, path string, v any) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		if strings.Contains(err.Error(), `unknown field "password"`) {
+			log.Fatalf("%s file %s: password is not supported, put the output of -hash-password in password_hash", what, path)
+		}
+		log.Fatalf("%s file %s: %v", what, path, err)
+	}
+}
+
+// listenAddr listens on a TCP address or on unix:///path/to.sock.
+func listenAddr(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, domain.UnixScheme)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+
+	// a socket left behind by a previous run would fail the bind
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	return net.Listen("unix", path)
+}
+
+func serverTLSConfig(certFile, keyFile, clientCA string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if clientCA != "" {
+		pem, err := os.ReadFile(clientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", clientCA)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// printHash reads a password from the first line of stdin and prints the
+// hash to put in the password_hash of a room or user.
+func printHash() {
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		log.Fatal("no password on stdin")
+	}
+	hash, err := andrewd.HashPassword(strings.TrimRight(line, "\r\n"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(hash)
+}
+
+func main() {
+	listen := flag.String("listen", ":4567", "address to listen on, unix:///path for a unix socket")
+	roomsPath := flag.String("rooms", "", "JSON file with the list of rooms")
+	usersPath := flag.String("users", "", "JSON file with the users that sign in, everyone else is a guest")
+	name := flag.String("name", "andrewd", "server name announced to clients")
+	certFile := flag.String("cert", "", "PEM certificate, enables TLS")
+	keyFile := flag.String("key", "", "PEM private key of -cert")
+	clientCA := flag.String("client-ca", "", "PEM CA bundle, requires client certificates")
+	wsListen := flag.String("ws", "", "address to accept WebSocket clients on, disabled if empty")
+	wsPath := flag.String("ws-path", "/ws", "WebSocket endpoint path")
+	userRate := flag.Float64("user-rate", 2, "messages per second a user may send, 0 for no limit")
+	userBurst := flag.Int("user-burst", 10, "messages a user may send at once")
+	roomRate := flag.Float64("room-rate", 10, "messages per second a room takes, 0 for no limit")
+	roomBurst := flag.Int("room-burst", 30, "messages a room takes at once")
+	hashPassword := flag.Bool("hash-password", false, "print the hash of a room or user password read from stdin and exit")
+	flag.Parse()
+
+	if *hashPassword {
+		printHash()
+		return
+	}
+
+	logger := log.New(os.Stderr, "andrewd: ", log.LstdFlags)
+	srv := andrewd.New(andrewd.Config{
+		Name:      *name,
+		Rooms:     loadRooms(*roomsPath),
+		Users:     loadUsers(*usersPath),
+		Logger:    logger,
+		UserLimit: ratelimit.Limit{Rate: *userRate, Burst: *userBurst},
+		RoomLimit: ratelimit.Limit{Rate: *roomRate, Burst: *roomBurst},
+	})
+
+	var tlsCfg *tls.Config
+	if *certFile != "" {
+		cfg, err := serverTLSConfig(*certFile, *keyFile, *clientCA)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		tlsCfg = cfg
+	}
+
+	l, err := listenAddr(*listen)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if tlsCfg != nil {
+		l = tls.NewListener(l, tlsCfg)
+	}
+	logger.Printf("listening on %s", l.Addr())
+
+	var httpSrv *http.Server
+	if *wsListen != "" {
+		mux := http.NewServeMux()
+		mux.Handle(*wsPath, srv.WebSocketHandler())
+		httpSrv = &http.Server{
+			Addr:      *wsListen,
+			Handler:   mux,
+			TLSConfig: tlsCfg,
+		}
+		go func() {
+			logger.Printf("accepting WebSocket clients on %s%s", *wsListen, *wsPath)
+			var err error
+			if tlsCfg != nil {
+				err = httpSrv.ListenAndServeTLS("", "")
+			} else {
+				err = httpSrv.ListenAndServe()
+			}
+			if err != nil && err != http.ErrServerClosed {
+				logger.Fatal(err)
+			}
+		}()
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		logger.Print("shutting down")
+		if httpSrv != nil {
+			httpSrv.Close()
+		}
+		srv.Close()
+	}()
+
+	if err := srv.Serve(l); err != nil && err != andrewd.ErrServerClosed {
+		logger.Fatal(err)
+	}
+}
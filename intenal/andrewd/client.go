@@ -0,0 +1,320 @@
+package andrewd
+
+import (
+	"andrew_chat/intenal/protocol"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	sendQueueSize    = 64
+	handshakeTimeout = 10 * time.Second
+)
+
+// capabilities implemented by andrewd
+var serverCaps = protocol.Caps{protocol.CapReceipts, protocol.CapCompression}
+
+// client is one accepted connection. Frames are written by writeLoop only,
+// everything else queues them with send.
+type client struct {
+	srv  *Server
+	conn net.Conn
+	dec  *protocol.Decoder
+	enc  *protocol.Encoder
+	out  chan protocol.Frame
+	done chan struct{}
+	once sync.Once
+
+	// set once by handshake; user is the name of a guest unless signedIn
+	user     string
+	signedIn bool
+	caps     protocol.Caps
+	// guarded by Server.mu
+	rooms map[string]*room
+}
+
+func newClient(srv *Server, conn net.Conn) *client {
+	return &client{
+		srv:   srv,
+		conn:  conn,
+		dec:   protocol.NewDecoder(conn),
+		enc:   protocol.NewEncoder(conn),
+		out:   make(chan protocol.Frame, sendQueueSize),
+		done:  make(chan struct{}),
+		rooms: make(map[string]*room),
+	}
+}
+
+func (c *client) close() {
+	c.once.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// send queues a frame. A client that does not keep up with its queue is
+// disconnected rather than allowed to block the room.
+func (c *client) send(t protocol.Type, payload any) {
+	f, err := protocol.NewFrame(t, payload)
+	if err != nil {
+		c.srv.logf("%s: encode %s: %v", c.conn.RemoteAddr(), t, err)
+		return
+	}
+
+	select {
+	case c.out <- f:
+	case <-c.done:
+	default:
+		c.srv.logf("%s: send queue overflow, dropping client", c.conn.RemoteAddr())
+		c.close()
+	}
+}
+
+func (c *client) sendError(id string, e *protocol.Error) {
+	e.ID = id
+	c.send(protocol.TypeError, e)
+}
+
+func (c *client) writeLoop() {
+	for {
+		select {
+		case f := <-c.out:
+			if err := c.enc.Encode(f); err != nil {
+				c.close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *client) serve() {
+	defer c.close()
+
+	if err := c.handshake(); err != nil {
+		c.srv.logf("%s: handshake: %v", c.conn.RemoteAddr(), err)
+		return
+	}
+	go c.writeLoop()
+
+	for {
+		f, err := c.dec.Decode()
+		if err != nil {
+			return
+		}
+		c.handle(f)
+	}
+}
+
+// handshake runs before writeLoop is started, so it writes with enc directly.
+func (c *client) handshake() error {
+	c.conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	var hello protocol.Hello
+	if err := c.expect(protocol.TypeHello, &hello); err != nil {
+		if errors.Is(err, protocol.ErrUnsupportedVersion) {
+			return c.reject("", protocol.CodeBadRequest, err.Error())
+		}
+		return err
+	}
+	if hello.Version < protocol.MinVersion {
+		return c.reject("", protocol.CodeBadRequest, "unsupported protocol version")
+	}
+	version := min(hello.Version, protocol.Version)
+	c.caps = serverCaps.Intersect(hello.Caps)
+	var compress []string
+	if algo := pickCompression(hello.Compress); algo != "" && c.caps.Has(protocol.CapCompression) {
+		compress = []string{algo}
+	}
+	if err := c.write(protocol.TypeHello, protocol.Hello{
+		Version:  version,
+		Agent:    c.srv.cfg.Name,
+		Caps:     c.caps,
+		Compress: compress,
+	}); err != nil {
+		return err
+	}
+	c.enc.SetVersion(version)
+
+	var auth protocol.Auth
+	for attempt := 1; ; attempt++ {
+		if err := c.expect(protocol.TypeAuth, &auth); err != nil {
+			return err
+		}
+		signedIn, e := c.srv.authenticate(c, auth)
+		if e == nil {
+			c.user, c.signedIn = auth.Username, signedIn
+			break
+		}
+		if e.Code != protocol.CodeUnauthorized || attempt == maxAuthAttempts {
+			return c.reject(auth.ID, e.Code, e.Text)
+		}
+		// the client may ask the user and try again
+		e.ID = auth.ID
+		if err := c.write(protocol.TypeError, e); err != nil {
+			return err
+		}
+		c.conn.SetDeadline(time.Now().Add(passwordTimeout))
+	}
+
+	if err := c.write(protocol.TypeAck, protocol.Ack{ID: auth.ID}); err != nil {
+		return err
+	}
+	if compress == nil {
+		return nil
+	}
+	if err := c.enc.Compress(compress[0]); err != nil {
+		return err
+	}
+	return c.dec.Decompress(compress[0])
+}
+
+// pickCompression returns the first offered algorithm supported here.
+func pickCompression(offered []string) string {
+	for _, algo := range offered {
+		if protocol.Caps(protocol.Compressions).Has(algo) {
+			return algo
+		}
+	}
+	return ""
+}
+
+func (c *client) expect(t protocol.Type, v any) error {
+	f, err := c.dec.Decode()
+	if err != nil {
+		return err
+	}
+	if f.Type != t {
+		return c.reject("", protocol.CodeBadRequest, "expected "+t.String()+" frame")
+	}
+	if err := f.Decode(v); err != nil {
+		return c.reject("", protocol.CodeBadRequest, err.Error())
+	}
+	return nil
+}
+
+func (c *client) write(t protocol.Type, payload any) error {
+	f, err := protocol.NewFrame(t, payload)
+	if err != nil {
+		return err
+	}
+	return c.enc.Encode(f)
+}
+
+func (c *client) reject(id, code, text string) error {
+	e := protocol.Error{ID: id, Code: code, Text: text}
+	c.write(protocol.TypeError, e)
+	return errors.New(e.Error())
+}
+
+func (c *client) handle(f protocol.Frame) {
+	switch f.Type {
+	case protocol.TypeJoin:
+		var p protocol.Join
+		if err := f.Decode(&p); err != nil {
+			c.sendError("", &protocol.Error{Code: protocol.CodeBadRequest, Text: err.Error()})
+			return
+		}
+		if e := c.srv.join(c, p); e != nil {
+			c.sendError(p.ID, e)
+			return
+		}
+		c.send(protocol.TypeAck, protocol.Ack{ID: p.ID})
+
+	case protocol.TypeChatPassword:
+		var p protocol.ChatPassword
+		if err := f.Decode(&p); err != nil {
+			c.sendError("", &protocol.Error{Code: protocol.CodeBadRequest, Text: err.Error()})
+			return
+		}
+		if e := c.srv.setPassword(c, p); e != nil {
+			c.sendError(p.ID, e)
+			return
+		}
+		c.send(protocol.TypeAck, protocol.Ack{ID: p.ID})
+
+	case protocol.TypeMember:
+		var p protocol.Member
+		if err := f.Decode(&p); err != nil {
+			c.sendError("", &protocol.Error{Code: protocol.CodeBadRequest, Text: err.Error()})
+			return
+		}
+		if e := c.srv.member(c, p); e != nil {
+			c.sendError(p.ID, e)
+			return
+		}
+		c.send(protocol.TypeAck, protocol.Ack{ID: p.ID})
+
+	case protocol.TypeLeave:
+		var p protocol.Leave
+		if err := f.Decode(&p); err != nil {
+			c.sendError("", &protocol.Error{Code: protocol.CodeBadRequest, Text: err.Error()})
+			return
+		}
+		c.srv.leave(c, p.Chat)
+		c.send(protocol.TypeAck, protocol.Ack{ID: p.ID})
+
+	case protocol.TypeMessage:
+		var p protocol.Message
+		if err := f.Decode(&p); err != nil {
+			c.sendError("", &protocol.Error{Code: protocol.CodeBadRequest, Text: err.Error()})
+			return
+		}
+		// the ID is what retries are recognized by
+		if p.ID == "" {
+			c.sendError("", &protocol.Error{Code: protocol.CodeBadRequest, Text: "message id is empty"})
+			return
+		}
+		msg, e := c.srv.publish(c, p)
+		if e != nil {
+			c.sendError(p.ID, e)
+			return
+		}
+		c.send(protocol.TypeAck, protocol.Ack{ID: msg.ID, Seq: msg.Seq})
+
+	case protocol.TypeReceipt:
+		var p protocol.Receipt
+		if err := f.Decode(&p); err != nil {
+			c.sendError("", &protocol.Error{Code: protocol.CodeBadRequest, Text: err.Error()})
+			return
+		}
+		if p.State != protocol.ReceiptDelivered && p.State != protocol.ReceiptRead {
+			c.sendError("", &protocol.Error{Code: protocol.CodeBadRequest, Text: "unknown receipt state " + p.State})
+			return
+		}
+		// receipts are not acked, there is nothing to retry
+		if e := c.srv.receipt(c, p); e != nil {
+			c.sendError("", e)
+		}
+
+	case protocol.TypeList:
+		var p protocol.List
+		if err := f.Decode(&p); err != nil {
+			c.sendError("", &protocol.Error{Code: protocol.CodeBadRequest, Text: err.Error()})
+			return
+		}
+		c.send(protocol.TypeChats, protocol.Chats{ID: p.ID, Chats: c.srv.list(c)})
+
+	case protocol.TypeGoodbye:
+		c.srv.logf("%s: %s said goodbye", c.conn.RemoteAddr(), c.user)
+		c.close()
+
+	case protocol.TypePing:
+		var p protocol.Ping
+		if err := f.Decode(&p); err != nil {
+			c.sendError("", &protocol.Error{Code: protocol.CodeBadRequest, Text: err.Error()})
+			return
+		}
+		c.send(protocol.TypePong, protocol.Pong{Time: p.Time})
+
+	default:
+		c.sendError("", &protocol.Error{
+			Code: protocol.CodeBadRequest,
+			Text: "unexpected " + f.Type.String() + " frame",
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package andrewd
+
+import (
+	"andrew_chat/intenal/protocol"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// A group with an owner is managed: only users holding a role may join it.
+// Users get the member role by an invite of an owner or admin, or by joining
+// with the password of a protected group. Removed users need a new invite,
+// the password does not let them back.
+
+var roleRank = map[string]int{
+	protocol.RoleMember: 1,
+	protocol.RoleAdmin:  2,
+	protocol.RoleOwner:  3,
+}
+
+// managed reports whether the room restricts membership to its roles.
+func (r *room) managed() bool {
+	return r.group && r.owner != ""
+}
+
+// announce publishes a system message to the members.
+//
+// caller holds Server.mu
+func (r *room) announce(format string, args ...any) {
+	r.seq++
+	msg := protocol.Message{
+		ID:     uuid.NewString(),
+		Chat:   r.name,
+		Text:   fmt.Sprintf(format, args...),
+		Seq:    r.seq,
+		Time:   time.Now().UTC(),
+		System: true,
+	}
+	r.remember(msg.ID, msg.Seq)
+	r.last = msg.Time
+
+	for c := range r.members {
+		c.send(protocol.TypeMessage, msg)
+	}
+}
+
+// kick removes user from the room and tells its connected clients.
+//
+// caller holds Server.mu
+func (r *room) kick(user string) {
+	delete(r.roles, user)
+	r.kicked[user] = true
+
+	for c := range r.members {
+		if c.user != user {
+			continue
+		}
+		delete(r.members, c)
+		delete(c.rooms, r.name)
+		c.send(protocol.TypeLeave, protocol.Leave{Chat: r.name})
+	}
+}
+
+// member applies a membership change sent by c.
+func (s *Server) member(c *client, p protocol.Member) *protocol.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.rooms[p.Chat]
+	if !ok {
+		return &protocol.Error{Code: protocol.CodeNotFound, Text: "no such chat: " + p.Chat}
+	}
+	if !r.managed() {
+		return &protocol.Error{Code: protocol.CodeForbidden, Text: p.Chat + " has no members to manage"}
+	}
+	if p.User == "" {
+		return &protocol.Error{Code: protocol.CodeBadRequest, Text: "user is empty"}
+	}
+	if !c.signedIn {
+		return &protocol.Error{Code: protocol.CodeForbidden, Text: "sign in to manage members"}
+	}
+
+	actor := roleRank[r.roles[c.user]]
+	role := r.roles[p.User]
+	switch p.Action {
+	case protocol.MemberInvite:
+		if actor < roleRank[protocol.RoleAdmin] {
+			return &protocol.Error{Code: protocol.CodeForbidden, Text: "only admins may invite"}
+		}
+		if role != "" {
+			return &protocol.Error{Code: protocol.CodeBadRequest, Text: p.User + " is a member already"}
+		}
+		r.roles[p.User] = protocol.RoleMember
+		delete(r.kicked, p.User)
+		r.announce("%s invited %s", c.user, p.User)
+
+	case protocol.MemberKick:
+		if role == "" {
+			return &protocol.Error{Code: protocol.CodeNotFound, Text: p.User + " is not a member"}
+		}
+		if actor < roleRank[protocol.RoleAdmin] || actor <= roleRank[role] {
+			return &protocol.Error{Code: protocol.CodeForbidden, Text: "you may not remove " + p.User}
+		}
+		// the removed user sees why
+		r.announce("%s removed %s", c.user, p.User)
+		r.kick(p.User)
+
+	case protocol.MemberPromote, protocol.MemberDemote:
+		if actor < roleRank[protocol.RoleOwner] {
+			return &protocol.Error{Code: protocol.CodeForbidden, Text: "only the owner may change roles"}
+		}
+		from, to := protocol.RoleMember, protocol.RoleAdmin
+		if p.Action == protocol.MemberDemote {
+			from, to = to, from
+		}
+		if role != from {
+			return &protocol.Error{Code: protocol.CodeBadRequest, Text: p.User + " is not " + withArticle(from)}
+		}
+		r.roles[p.User] = to
+		r.announce("%s made %s %s", c.user, p.User, withArticle(to))
+
+	default:
+		return &protocol.Error{Code: protocol.CodeBadRequest, Text: "unknown member action: " + p.Action}
+	}
+
+	s.logf("room %s: %s %s %s", r.name, c.user, p.Action, p.User)
+	return nil
+}
+
+func withArticle(role string) string {
+	if role == protocol.RoleAdmin {
+		return "an " + role
+	}
+	return "a " + role
+}
@@ -0,0 +1,113 @@
+package andrewd
+
+import (
+	"andrew_chat/intenal/protocol"
+	"andrew_chat/intenal/ratelimit"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Chat passwords are kept as PBKDF2-SHA256 hashes only, encoded as
+// pbkdf2-sha256$<iterations>$<salt>$<key> with unpadded base64.
+const (
+	hashScheme     = "pbkdf2-sha256"
+	hashIterations = 600_000
+	saltLen        = 16
+	keyLen         = 32
+)
+
+var errBadHash = errors.New("malformed password hash")
+
+// HashPassword returns a salted hash of password to keep instead of it.
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, hashIterations, keyLen)
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawStdEncoding
+	return fmt.Sprintf("%s$%d$%s$%s",
+		hashScheme, hashIterations, enc.EncodeToString(salt), enc.EncodeToString(key)), nil
+}
+
+// checkPassword reports whether password matches hash. It is slow on
+// purpose, call it without holding Server.mu.
+func checkPassword(hash, password string) (bool, error) {
+	iter, salt, want, err := parseHash(hash)
+	if err != nil {
+		return false, err
+	}
+
+	key, err := pbkdf2.Key(sha256.New, password, salt, iter, len(want))
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(key, want) == 1, nil
+}
+
+// parseHash splits a hash made by HashPassword into its parts.
+func parseHash(hash string) (iter int, salt, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != hashScheme {
+		return 0, nil, nil, errBadHash
+	}
+	iter, err = strconv.Atoi(parts[1])
+	if err != nil || iter <= 0 {
+		return 0, nil, nil, errBadHash
+	}
+	enc := base64.RawStdEncoding
+	if salt, err = enc.DecodeString(parts[2]); err != nil {
+		return 0, nil, nil, errBadHash
+	}
+	if key, err = enc.DecodeString(parts[3]); err != nil || len(key) == 0 {
+		return 0, nil, nil, errBadHash
+	}
+	return iter, salt, key, nil
+}
+
+// password checks of a remote host: they are slow on purpose, guessing
+// must be slow too
+var guessLimit = ratelimit.Limit{Rate: 0.2, Burst: 5}
+
+// guess checks password against hash for c, what names the account or room
+// in the log. Every check takes a token of the host of c, a right password
+// gives it back.
+func (s *Server) guess(c *client, what, hash, password string) (bool, *protocol.Error) {
+	host := c.conn.RemoteAddr().String()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	s.mu.Lock()
+	b, ok := s.guesses[host]
+	if !ok {
+		b = ratelimit.NewBucket(guessLimit)
+		s.guesses[host] = b
+	}
+	s.mu.Unlock()
+	if ok, wait := b.Allow(); !ok {
+		return false, rateLimited("too many password attempts", wait)
+	}
+
+	ok, err := checkPassword(hash, password)
+	if err != nil {
+		s.logf("%s: %v", what, err)
+		return false, &protocol.Error{Code: protocol.CodeInternal, Text: "cannot check the password"}
+	}
+	if ok {
+		b.Refund()
+	}
+	return ok, nil
+}
@@ -0,0 +1,193 @@
+package andrewd
+
+import (
+	"andrew_chat/intenal/protocol"
+	"andrew_chat/intenal/ratelimit"
+	"time"
+)
+
+const (
+	// private chats are dialogs
+	privateRoomLimit = 2
+	// message IDs remembered per room to drop resent messages
+	recentIDs = 1024
+)
+
+type RoomConfig struct {
+	Name  string `json:"name"`
+	Group bool   `json:"group"`
+	// user that may change the password; a group with an owner takes
+	// members only, see members.go
+	Owner   string   `json:"owner,omitempty"`
+	Admins  []string `json:"admins,omitempty"`
+	Members []string `json:"members,omitempty"`
+	// a password hash as made by HashPassword (andrewd -hash-password)
+	// makes the room protected
+	PasswordHash string `json:"password_hash,omitempty"`
+}
+
+type room struct {
+	name  string
+	group bool
+	owner string
+	// empty if the room is not protected
+	passwordHash string
+	members      map[*client]struct{}
+	seq          uint64
+
+	// seq of recently published message IDs, oldest first in order
+	seen  map[string]uint64
+	order []string
+
+	// messages taken from all members
+	limit *ratelimit.Bucket
+
+	// when the last message was published
+	last time.Time
+	// seq of the last read receipt by user name
+	read map[string]uint64
+
+	// role by user name, empty unless managed
+	roles map[string]string
+	// removed users, they need an invite to come back
+	kicked map[string]bool
+}
+
+func newRoom(cfg RoomConfig, limit ratelimit.Limit) (*room, error) {
+	hash := cfg.PasswordHash
+	if hash != "" {
+		// fail on start rather than on every join
+		if _, _, _, err := parseHash(hash); err != nil {
+			return nil, err
+		}
+	}
+
+	r := &room{
+		name:         cfg.Name,
+		group:        cfg.Group,
+		owner:        cfg.Owner,
+		passwordHash: hash,
+		members:      make(map[*client]struct{}),
+		seen:         make(map[string]uint64),
+		limit:        ratelimit.NewBucket(limit),
+		read:         make(map[string]uint64),
+		roles:        make(map[string]string),
+		kicked:       make(map[string]bool),
+	}
+	if r.managed() {
+		for _, user := range cfg.Members {
+			r.roles[user] = protocol.RoleMember
+		}
+		for _, user := range cfg.Admins {
+			r.roles[user] = protocol.RoleAdmin
+		}
+		r.roles[r.owner] = protocol.RoleOwner
+	}
+	return r, nil
+}
+
+// info describes the room to user.
+//
+// caller holds Server.mu
+func (r *room) info(c *client) protocol.ChatInfo {
+	_, joined := r.members[c]
+	var role string
+	if c.signedIn {
+		role = r.roles[c.user]
+		// owners of private chats have no other roles, but a password
+		if r.owner != "" && r.owner == c.user {
+			role = protocol.RoleOwner
+		}
+	}
+	return protocol.ChatInfo{
+		Name:         r.name,
+		Group:        r.group,
+		Protected:    r.passwordHash != "",
+		Joined:       joined,
+		Role:         role,
+		Seq:          r.seq,
+		Unread:       r.seq - min(r.read[c.user], r.seq),
+		LastActivity: r.last,
+	}
+}
+
+// join adds c to the members, the password was checked by the caller.
+//
+// caller holds Server.mu
+func (r *room) join(c *client) *protocol.Error {
+	if _, ok := r.members[c]; ok {
+		return nil
+	}
+	if !r.group && len(r.members) >= privateRoomLimit {
+		return &protocol.Error{Code: protocol.CodeForbidden, Text: "private chat is full"}
+	}
+	r.members[c] = struct{}{}
+	return nil
+}
+
+// caller holds Server.mu
+func (r *room) leave(c *client) {
+	delete(r.members, c)
+}
+
+// publish broadcasts msg to all members. A message with an ID published
+// before is a client retry: it is not broadcast again and keeps its seq.
+//
+// caller holds Server.mu
+func (r *room) publish(author string, msg protocol.Message) protocol.Message {
+	if seq, ok := r.seen[msg.ID]; ok {
+		msg.Chat = r.name
+		msg.Author = author
+		msg.Seq = seq
+		return msg
+	}
+
+	r.seq++
+	r.remember(msg.ID, r.seq)
+	msg.Chat = r.name
+	msg.Author = author
+	msg.Seq = r.seq
+	msg.Time = time.Now().UTC()
+	msg.System = false
+	r.last = msg.Time
+	// own messages are read
+	r.read[author] = r.seq
+
+	for c := range r.members {
+		c.send(protocol.TypeMessage, msg)
+	}
+	return msg
+}
+
+// published reports whether a message with id was published recently.
+//
+// caller holds Server.mu
+func (r *room) published(id string) bool {
+	_, ok := r.seen[id]
+	return ok
+}
+
+// relay passes a receipt of from to the other members that negotiated
+// receipts. Read receipts also move the unread mark of from.
+//
+// caller holds Server.mu
+func (r *room) relay(from *client, p protocol.Receipt) {
+	if p.State == protocol.ReceiptRead && p.Seq > r.read[from.user] {
+		r.read[from.user] = min(p.Seq, r.seq)
+	}
+	for c := range r.members {
+		if c != from && c.caps.Has(protocol.CapReceipts) {
+			c.send(protocol.TypeReceipt, p)
+		}
+	}
+}
+
+// caller holds Server.mu
+func (r *room) remember(id string, seq uint64) {
+	if len(r.order) >= recentIDs {
+		delete(r.seen, r.order[0])
+		r.order = r.order[1:]
+	}
+	r.seen[id] = seq
+	r.order = append(r.order, id)
+}
@@ -0,0 +1,362 @@
+package andrewd
+
+import (
+	"andrew_chat/intenal/protocol"
+	"andrew_chat/intenal/ratelimit"
+	"errors"
+	"log"
+	"net"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	// announced in the hello frame
+	Name   string
+	Rooms  []RoomConfig
+	Users  []UserConfig
+	Logger *log.Logger
+	// messages a user may send across all connections and a room may
+	// take from all members, zero for no limit
+	UserLimit ratelimit.Limit
+	RoomLimit ratelimit.Limit
+}
+
+// Server is the andrewd chat daemon. It serves any number of listeners and
+// clients concurrently.
+type Server struct {
+	cfg Config
+
+	mu        sync.Mutex
+	rooms     map[string]*room
+	clients   map[*client]struct{}
+	listeners map[net.Listener]struct{}
+	// message buckets by user name
+	users map[string]*ratelimit.Bucket
+	// password checks by remote host, see guess
+	guesses map[string]*ratelimit.Bucket
+	// password hashes of the configured users, by name; read only
+	accounts map[string]string
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+func New(cfg Config) *Server {
+	s := &Server{
+		cfg:       cfg,
+		rooms:     make(map[string]*room),
+		clients:   make(map[*client]struct{}),
+		listeners: make(map[net.Listener]struct{}),
+		users:     make(map[string]*ratelimit.Bucket),
+		accounts:  make(map[string]string),
+		guesses:   make(map[string]*ratelimit.Bucket),
+	}
+	for _, u := range cfg.Users {
+		// kept anyway, the name must not be free for guests
+		if _, _, _, err := parseHash(u.PasswordHash); err != nil {
+			s.logf("user %s cannot sign in: %v", u.Name, err)
+		}
+		s.accounts[u.Name] = u.PasswordHash
+	}
+	for _, rc := range cfg.Rooms {
+		r, err := newRoom(rc, cfg.RoomLimit)
+		if err != nil {
+			s.logf("room %s not served: %v", rc.Name, err)
+			continue
+		}
+		s.rooms[rc.Name] = r
+
+		// roles are held by signed in users only
+		if _, ok := s.accounts[rc.Owner]; rc.Owner != "" && !ok {
+			s.logf("room %s: owner %s has no account", rc.Name, rc.Owner)
+		}
+		for _, user := range slices.Concat(rc.Admins, rc.Members) {
+			if _, ok := s.accounts[user]; !ok {
+				s.logf("room %s: %s has no account", rc.Name, user)
+			}
+		}
+	}
+	return s
+}
+
+func (s *Server) logf(format string, args ...any) {
+	if s.cfg.Logger != nil {
+		s.cfg.Logger.Printf(format, args...)
+	}
+}
+
+var ErrServerClosed = errors.New("andrewd: server closed")
+
+// Serve accepts connections on l until l fails or Close is called.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn serves one accepted connection and returns when it is closed.
+func (s *Server) ServeConn(conn net.Conn) error {
+	c := newClient(s, conn)
+	if !s.addClient(c) {
+		conn.Close()
+		return ErrServerClosed
+	}
+	defer s.wg.Done()
+	defer s.removeClient(c)
+
+	c.serve()
+	return nil
+}
+
+// Close stops all listeners, disconnects every client and waits for their
+// goroutines to finish.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for c := range s.clients {
+		c.close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return nil
+}
+
+// addClient registers c with the wait group of Close.
+func (s *Server) addClient(c *client) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.clients[c] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *Server) removeClient(c *client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range c.rooms {
+		r.leave(c)
+	}
+	delete(s.clients, c)
+}
+
+func (s *Server) join(c *client, p protocol.Join) *protocol.Error {
+	s.mu.Lock()
+	r, ok := s.rooms[p.Chat]
+	if !ok {
+		s.mu.Unlock()
+		return &protocol.Error{Code: protocol.CodeNotFound, Text: "no such chat: " + p.Chat}
+	}
+	_, member := r.members[c]
+	role := r.roles[c.user]
+	hash := r.passwordHash
+	s.mu.Unlock()
+
+	// roles go by name, which guests choose freely
+	if r.managed() && !c.signedIn {
+		return &protocol.Error{Code: protocol.CodeForbidden, Text: r.name + " is for signed in members only"}
+	}
+	// members and the owner skip the password
+	privileged := c.signedIn && (role != "" || r.owner == c.user)
+	if !member && !privileged {
+		if e := s.admit(c, r, hash, p.Password); e != nil {
+			return e
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.passwordHash != hash {
+		return &protocol.Error{Code: protocol.CodeUnauthorized, Text: "chat password was changed, try again"}
+	}
+	if r.managed() && r.kicked[c.user] {
+		return &protocol.Error{Code: protocol.CodeForbidden, Text: "you were removed from " + r.name + ", ask for an invite"}
+	}
+	if e := r.join(c); e != nil {
+		return e
+	}
+	c.rooms[r.name] = r
+	if r.managed() && r.roles[c.user] == "" {
+		r.roles[c.user] = protocol.RoleMember
+		r.announce("%s joined with the password", c.user)
+	}
+	return nil
+}
+
+// admit checks whether c may join r as a new member. hash is the
+// password hash of r, read under s.mu; hashing is slow, other clients are
+// served meanwhile.
+func (s *Server) admit(c *client, r *room, hash, password string) *protocol.Error {
+	s.mu.Lock()
+	managed, kicked := r.managed(), r.kicked[c.user]
+	s.mu.Unlock()
+
+	switch {
+	case managed && kicked:
+		return &protocol.Error{Code: protocol.CodeForbidden, Text: "you were removed from " + r.name + ", ask for an invite"}
+	case managed && hash == "":
+		return &protocol.Error{Code: protocol.CodeForbidden, Text: r.name + " is invite only"}
+	case hash == "":
+		return nil
+	}
+	if password == "" {
+		return &protocol.Error{Code: protocol.CodeUnauthorized, Text: "chat is protected, password required"}
+	}
+	ok, e := s.guess(c, "room "+r.name, hash, password)
+	if e != nil {
+		return e
+	}
+	if !ok {
+		return &protocol.Error{Code: protocol.CodeUnauthorized, Text: "wrong chat password"}
+	}
+	return nil
+}
+
+// setPassword changes the password of a chat owned by c, empty removes it.
+func (s *Server) setPassword(c *client, p protocol.ChatPassword) *protocol.Error {
+	s.mu.Lock()
+	r, ok := s.rooms[p.Chat]
+	s.mu.Unlock()
+	if !ok {
+		return &protocol.Error{Code: protocol.CodeNotFound, Text: "no such chat: " + p.Chat}
+	}
+	// the owner never changes; guests choose their name
+	if r.owner == "" || r.owner != c.user || !c.signedIn {
+		return &protocol.Error{Code: protocol.CodeForbidden, Text: "only the owner may change the chat password"}
+	}
+
+	var hash string
+	if p.Password != "" {
+		var err error
+		if hash, err = HashPassword(p.Password); err != nil {
+			s.logf("room %s: %v", r.name, err)
+			return &protocol.Error{Code: protocol.CodeInternal, Text: "cannot hash the chat password"}
+		}
+	}
+
+	s.mu.Lock()
+	r.passwordHash = hash
+	s.mu.Unlock()
+	if hash == "" {
+		s.logf("room %s: password removed by %s", r.name, c.user)
+	} else {
+		s.logf("room %s: password changed by %s", r.name, c.user)
+	}
+	return nil
+}
+
+func (s *Server) leave(c *client, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r, ok := c.rooms[name]; ok {
+		r.leave(c)
+		delete(c.rooms, name)
+	}
+}
+
+func (s *Server) publish(c *client, msg protocol.Message) (protocol.Message, *protocol.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := c.rooms[msg.Chat]
+	if !ok {
+		return msg, &protocol.Error{Code: protocol.CodeForbidden, Text: "not a member of " + msg.Chat}
+	}
+	// a resend of a published message costs nothing
+	if !r.published(msg.ID) {
+		if e := s.limit(c.user, r); e != nil {
+			return msg, e
+		}
+	}
+	return r.publish(c.user, msg), nil
+}
+
+// limit takes a token of the user and of the room.
+//
+// caller holds s.mu
+func (s *Server) limit(user string, r *room) *protocol.Error {
+	b, ok := s.users[user]
+	if !ok {
+		b = ratelimit.NewBucket(s.cfg.UserLimit)
+		s.users[user] = b
+	}
+	if ok, wait := b.Allow(); !ok {
+		return rateLimited("you are sending messages too fast", wait)
+	}
+	if ok, wait := r.limit.Allow(); !ok {
+		// the message is not taken, it does not count for the user
+		b.Refund()
+		return rateLimited(r.name+" takes too many messages", wait)
+	}
+	return nil
+}
+
+func rateLimited(text string, wait time.Duration) *protocol.Error {
+	return &protocol.Error{
+		Code:         protocol.CodeRateLimited,
+		Text:         "slow down: " + text,
+		RetryAfterMs: max(wait.Milliseconds(), 1),
+	}
+}
+
+func (s *Server) receipt(c *client, p protocol.Receipt) *protocol.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := c.rooms[p.Chat]
+	if !ok {
+		return &protocol.Error{Code: protocol.CodeForbidden, Text: "not a member of " + p.Chat}
+	}
+	p.User = c.user
+	r.relay(c, p)
+	return nil
+}
+
+// list describes all rooms to c, ordered by name.
+func (s *Server) list(c *client) []protocol.ChatInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chats := make([]protocol.ChatInfo, 0, len(s.rooms))
+	for _, r := range s.rooms {
+		chats = append(chats, r.info(c))
+	}
+	sort.Slice(chats, func(i, j int) bool {
+		return chats[i].Name < chats[j].Name
+	})
+	return chats
+}
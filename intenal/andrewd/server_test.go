@@ -0,0 +1,281 @@
+package andrewd
+
+import (
+	"andrew_chat/intenal/protocol"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// startServer serves cfg on a local port and returns its address.
+func startServer(t *testing.T, cfg Config) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(cfg)
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return l.Addr().String()
+}
+
+// peer speaks the wire protocol frame by frame, without the checks of the
+// client.
+type peer struct {
+	conn net.Conn
+	enc  *protocol.Encoder
+	dec  *protocol.Decoder
+}
+
+func dialPeer(t *testing.T, addr string) *peer {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &peer{
+		conn: conn,
+		enc:  protocol.NewEncoder(conn),
+		dec:  protocol.NewDecoder(conn),
+	}
+}
+
+func (p *peer) send(t *testing.T, typ protocol.Type, payload any) {
+	t.Helper()
+	f, err := protocol.NewFrame(typ, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.enc.Encode(f); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expect reads the next frame into v, it must be of type typ.
+func (p *peer) expect(t *testing.T, typ protocol.Type, v any) {
+	t.Helper()
+	f, err := p.dec.Decode()
+	if err != nil {
+		t.Fatalf("waiting for %s: %v", typ, err)
+	}
+	if f.Type != typ {
+		var e protocol.Error
+		f.Decode(&e)
+		t.Fatalf("got %s frame %+v, want %s", f.Type, e, typ)
+	}
+	if err := f.Decode(v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandshakeNewerClient(t *testing.T) {
+	addr := startServer(t, Config{Name: "test"})
+	p := dialPeer(t, addr)
+
+	// a client of a later version writes its hello at MinVersion
+	p.send(t, protocol.TypeHello, protocol.Hello{Version: protocol.Version + 1, Agent: "future"})
+	var hello protocol.Hello
+	p.expect(t, protocol.TypeHello, &hello)
+	if hello.Version != protocol.Version {
+		t.Fatalf("negotiated version %d, want %d", hello.Version, protocol.Version)
+	}
+	if err := p.enc.SetVersion(hello.Version); err != nil {
+		t.Fatal(err)
+	}
+
+	p.send(t, protocol.TypeAuth, protocol.Auth{ID: "1", Username: "al"})
+	var ack protocol.Ack
+	p.expect(t, protocol.TypeAck, &ack)
+	if ack.ID != "1" {
+		t.Errorf("auth ack for %q", ack.ID)
+	}
+}
+
+func TestHandshakeUnsupportedHeader(t *testing.T) {
+	addr := startServer(t, Config{Name: "test"})
+	p := dialPeer(t, addr)
+
+	// a header version this server cannot read
+	h := []byte{'A', 'C', protocol.Version + 1, byte(protocol.TypeHello), 0, 0, 0, 2, '{', '}'}
+	if _, err := p.conn.Write(h); err != nil {
+		t.Fatal(err)
+	}
+
+	var e protocol.Error
+	p.expect(t, protocol.TypeError, &e)
+	if e.Code != protocol.CodeBadRequest {
+		t.Errorf("error code %q, want %q", e.Code, protocol.CodeBadRequest)
+	}
+}
+
+// quickHash hashes like HashPassword with few iterations, to keep the tests
+// fast.
+func quickHash(t *testing.T, password string) string {
+	t.Helper()
+	salt := []byte("0123456789abcdef")
+	key, err := pbkdf2.Key(sha256.New, password, salt, 1000, keyLen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := base64.RawStdEncoding
+	return fmt.Sprintf("%s$%d$%s$%s", hashScheme, 1000, enc.EncodeToString(salt), enc.EncodeToString(key))
+}
+
+// hello opens the connection of p.
+func (p *peer) hello(t *testing.T) {
+	t.Helper()
+	p.send(t, protocol.TypeHello, protocol.Hello{Version: protocol.Version, Agent: "test"})
+	var hello protocol.Hello
+	p.expect(t, protocol.TypeHello, &hello)
+}
+
+// auth signs in and returns the error of the server, nil once acked.
+func (p *peer) auth(t *testing.T, user, password string) *protocol.Error {
+	t.Helper()
+	p.send(t, protocol.TypeAuth, protocol.Auth{ID: "auth", Username: user, Password: password})
+	return p.answer(t)
+}
+
+// answer reads the ack or error answering the last request.
+func (p *peer) answer(t *testing.T) *protocol.Error {
+	t.Helper()
+	for {
+		f, err := p.dec.Decode()
+		if err != nil {
+			t.Fatalf("waiting for an answer: %v", err)
+		}
+		switch f.Type {
+		case protocol.TypeAck:
+			return nil
+		case protocol.TypeError:
+			var e protocol.Error
+			if err := f.Decode(&e); err != nil {
+				t.Fatal(err)
+			}
+			return &e
+		}
+		// messages of the rooms joined
+	}
+}
+
+// signIn connects as user, a guest without password.
+func signIn(t *testing.T, addr, user, password string) *peer {
+	t.Helper()
+	p := dialPeer(t, addr)
+	p.hello(t)
+	if e := p.auth(t, user, password); e != nil {
+		t.Fatalf("sign in as %s: %v", user, e)
+	}
+	return p
+}
+
+func (p *peer) member(t *testing.T, chat, user, action string) *protocol.Error {
+	t.Helper()
+	p.send(t, protocol.TypeMember, protocol.Member{ID: action, Chat: chat, User: user, Action: action})
+	return p.answer(t)
+}
+
+func expectCode(t *testing.T, what string, e *protocol.Error, code string) {
+	t.Helper()
+	if e == nil || e.Code != code {
+		t.Errorf("%s: %v, want %s", what, e, code)
+	}
+}
+
+func teamConfig(t *testing.T) Config {
+	return Config{
+		Name: "test",
+		Users: []UserConfig{
+			{Name: "al", PasswordHash: quickHash(t, "al-secret")},
+			{Name: "bo", PasswordHash: quickHash(t, "bo-secret")},
+		},
+		Rooms: []RoomConfig{
+			{Name: "team", Group: true, Owner: "al", Members: []string{"bo"}},
+		},
+	}
+}
+
+func TestSignIn(t *testing.T) {
+	addr := startServer(t, teamConfig(t))
+	p := dialPeer(t, addr)
+	p.hello(t)
+
+	expectCode(t, "no password", p.auth(t, "al", ""), protocol.CodeUnauthorized)
+	expectCode(t, "wrong password", p.auth(t, "al", "guess"), protocol.CodeUnauthorized)
+	if e := p.auth(t, "al", "al-secret"); e != nil {
+		t.Fatalf("right password: %v", e)
+	}
+}
+
+func TestSignInAttempts(t *testing.T) {
+	addr := startServer(t, teamConfig(t))
+	p := dialPeer(t, addr)
+	p.hello(t)
+
+	for i := 0; i < maxAuthAttempts; i++ {
+		expectCode(t, "wrong password", p.auth(t, "al", "guess"), protocol.CodeUnauthorized)
+	}
+	if _, err := p.dec.Decode(); err == nil {
+		t.Error("connection still open after too many wrong passwords")
+	}
+}
+
+func TestGuestHasNoRoles(t *testing.T) {
+	addr := startServer(t, teamConfig(t))
+	eve := signIn(t, addr, "eve", "")
+
+	eve.send(t, protocol.TypeJoin, protocol.Join{ID: "join", Chat: "team"})
+	expectCode(t, "guest joins a managed group", eve.answer(t), protocol.CodeForbidden)
+	expectCode(t, "guest invites", eve.member(t, "team", "eve", protocol.MemberInvite), protocol.CodeForbidden)
+}
+
+func TestMemberActionsNeedRole(t *testing.T) {
+	addr := startServer(t, teamConfig(t))
+
+	bo := signIn(t, addr, "bo", "bo-secret")
+	expectCode(t, "member invites", bo.member(t, "team", "eve", protocol.MemberInvite), protocol.CodeForbidden)
+	expectCode(t, "member promotes", bo.member(t, "team", "bo", protocol.MemberPromote), protocol.CodeForbidden)
+
+	al := signIn(t, addr, "al", "al-secret")
+	if e := al.member(t, "team", "bo", protocol.MemberPromote); e != nil {
+		t.Fatalf("owner promotes: %v", e)
+	}
+	if e := bo.member(t, "team", "eve", protocol.MemberInvite); e != nil {
+		t.Errorf("admin invites: %v", e)
+	}
+}
+
+func TestOwnerActionsNeedSignIn(t *testing.T) {
+	// the owner has no account, anyone could take the name
+	addr := startServer(t, Config{Rooms: []RoomConfig{{Name: "team", Group: true, Owner: "zed"}}})
+	p := signIn(t, addr, "zed", "")
+
+	p.send(t, protocol.TypeChatPassword, protocol.ChatPassword{ID: "pw", Chat: "team", Password: "mine"})
+	expectCode(t, "guest sets the password", p.answer(t), protocol.CodeForbidden)
+}
+
+func TestJoinGuessesLimited(t *testing.T) {
+	addr := startServer(t, Config{Rooms: []RoomConfig{
+		{Name: "vault", Group: true, PasswordHash: quickHash(t, "open sesame")},
+	}})
+	p := signIn(t, addr, "eve", "")
+
+	for i := 0; i < guessLimit.Burst; i++ {
+		p.send(t, protocol.TypeJoin, protocol.Join{ID: "join", Chat: "vault", Password: "guess"})
+		expectCode(t, "wrong password", p.answer(t), protocol.CodeUnauthorized)
+	}
+	// the right one too, from now on guesses are throttled
+	p.send(t, protocol.TypeJoin, protocol.Join{ID: "join", Chat: "vault", Password: "open sesame"})
+	e := p.answer(t)
+	expectCode(t, "guess after the burst", e, protocol.CodeRateLimited)
+	if e != nil && e.RetryAfterMs <= 0 {
+		t.Errorf("retry after %d ms", e.RetryAfterMs)
+	}
+}
@@ -0,0 +1,48 @@
+package andrewd
+
+import (
+	"andrew_chat/intenal/protocol"
+	"time"
+)
+
+// A configured user signs in with the password. Every other name connects
+// as a guest: guests chat in open rooms, but they hold no roles and own
+// nothing, as anyone may connect with any name.
+
+const (
+	// wrong passwords a connection may try before it is dropped
+	maxAuthAttempts = 3
+	// the user types a password meanwhile
+	passwordTimeout = 2 * time.Minute
+)
+
+// UserConfig is an account of a user.
+type UserConfig struct {
+	Name string `json:"name"`
+	// a password hash as made by HashPassword (andrewd -hash-password)
+	PasswordHash string `json:"password_hash"`
+}
+
+// authenticate checks the auth of c. It reports whether the user signed in,
+// false for guests.
+func (s *Server) authenticate(c *client, auth protocol.Auth) (bool, *protocol.Error) {
+	if auth.Username == "" {
+		return false, &protocol.Error{Code: protocol.CodeBadRequest, Text: "username is empty"}
+	}
+	hash, ok := s.accounts[auth.Username]
+	if !ok {
+		return false, nil
+	}
+	if auth.Password == "" {
+		return false, &protocol.Error{Code: protocol.CodeUnauthorized, Text: "password required for " + auth.Username}
+	}
+
+	ok, e := s.guess(c, "user "+auth.Username, hash, auth.Password)
+	if e != nil {
+		return false, e
+	}
+	if !ok {
+		return false, &protocol.Error{Code: protocol.CodeUnauthorized, Text: "wrong password for " + auth.Username}
+	}
+	return true, nil
+}
@@ -0,0 +1,27 @@
+package andrewd
+
+import (
+	"andrew_chat/intenal/wsconn"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+)
+
+var upgrader = websocket.Upgrader{
+	// clients are not browsers, there is no origin to check
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// WebSocketHandler upgrades requests to WebSocket and serves the chat
+// protocol over them, for clients behind HTTP reverse proxies.
+func (s *Server) WebSocketHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// Upgrade has replied with an HTTP error already
+			s.logf("%s: websocket upgrade: %v", r.RemoteAddr, err)
+			return
+		}
+		s.ServeConn(wsconn.New(ws))
+	})
+}
@@ -2,12 +2,19 @@ package app
 
 import (
 	"andrew_chat/intenal/color"
-	"andrew_chat/intenal/config"
+	"andrew_chat/intenal/domain"
+	"andrew_chat/intenal/domain/chat"
 	"andrew_chat/intenal/server"
+	"andrew_chat/intenal/ui"
+	uichat "andrew_chat/intenal/ui/chat"
 	uisrv "andrew_chat/intenal/ui/server"
 	"andrew_chat/intenal/ui/types"
 	wm "andrew_chat/intenal/ui/window_manager"
+	"context"
+	"errors"
+	"fmt"
 	"strings"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
@@ -16,6 +23,8 @@ import (
 const (
 	xPadding = 1
 	yPadding = 0
+
+	toastDuration = 4 * time.Second
 )
 
 // =============================================================================
@@ -41,25 +50,184 @@ func matchServerStatus(s int) string {
 // MainModel
 // =============================================================================
 
+// refresh of the reconnect countdown
+type tickMsg struct {
+	id int
+}
+
+type probeMsg server.Probe
+
+// hides the toast, unless a newer one replaced it
+type toastExpiredMsg struct {
+	id int
+}
+
 type MainModel struct {
 	wm    *wm.WindowManager
+	ss    *server.ServerService
 	focus int
 
 	width  int
 	height int
 
+	// only the latest countdown keeps ticking
+	tickID int
+
+	// shown in place of the footer until it expires
+	toast   string
+	toastID int
+
+	// open conversations by chat, a chat is left when its last one closes
+	open map[openChat]int
+}
+
+type openChat struct {
 	server string
-	status string
+	chat   string
 }
 
 func NewMainModel() *MainModel {
 	wm := wm.NewWM()
 	return &MainModel{
-		wm:     wm,
-		status: "disconnected",
+		wm:   wm,
+		ss:   server.NewServerService(),
+		open: make(map[openChat]int),
+	}
+}
+
+// listenServer waits for the next connection event of the server service.
+func (m *MainModel) listenServer() tea.Cmd {
+	return func() tea.Msg {
+		ev := <-m.ss.Events()
+		return types.ServerMsg{
+			Status:   ev.Status,
+			Server:   ev.Server,
+			Err:      ev.Err,
+			Attempt:  ev.Attempt,
+			RetryAt:  ev.RetryAt,
+			Latency:  ev.Latency,
+			Password: ev.Password,
+		}
+	}
+}
+
+// listenChats waits for the next chat event of the server service.
+func (m *MainModel) listenChats() tea.Cmd {
+	return func() tea.Msg {
+		ev := <-m.ss.ChatEvents()
+		return types.ChatMsg{
+			Server:  ev.Server,
+			Chat:    ev.Chat,
+			Message: ev.Message,
+			ID:      ev.ID,
+			State:   ev.State,
+			Err:     ev.Err,
+		}
+	}
+}
+
+// listenProbes waits for the next reachability probe. The server list
+// renders probes from m.ss, the message only triggers redraw.
+func (m *MainModel) listenProbes() tea.Cmd {
+	return func() tea.Msg {
+		return probeMsg(<-m.ss.ProbeEvents())
 	}
 }
 
+// showToast displays text in place of the footer for a while.
+func (m *MainModel) showToast(text string) tea.Cmd {
+	m.toast = text
+	m.toastID++
+	id := m.toastID
+	return tea.Tick(toastDuration, func(time.Time) tea.Msg {
+		return toastExpiredMsg{id: id}
+	})
+}
+
+func (m *MainModel) tick() tea.Cmd {
+	id := m.tickID
+	return tea.Tick(time.Second, func(time.Time) tea.Msg {
+		return tickMsg{id: id}
+	})
+}
+
+// confirmPinCmd asks whether to trust the new certificate key of srv.
+func (m *MainModel) confirmPinCmd(srv domain.Server, pinErr *server.PinMismatchError) tea.Cmd {
+	text := fmt.Sprintf("%s presented a certificate with a different key.\n\n"+
+		"Pinned: %s\nGot:    %s\n\n"+
+		"Accept the new key?", srv.Name, pinErr.Pinned, pinErr.Got)
+
+	confirm := ui.NewConfirm(text, func(ok bool) tea.Cmd {
+		if !ok {
+			return nil
+		}
+		if err := m.ss.AcceptPin(context.Background(), srv.ID, pinErr.Got); err != nil {
+			return ui.NewErrCmd("accept pin failed")
+		}
+		return nil
+	})
+	return ui.NewCreateCmd(types.PositionBotRight, confirm, true)
+}
+
+// passwordCmd opens a form answering req.
+func (m *MainModel) passwordCmd(req *server.PasswordRequest) tea.Cmd {
+	spec := []types.InputFieldSpec{
+		{
+			Name:   "password",
+			Title:  req.Text,
+			Secret: true,
+		},
+	}
+	form := ui.NewInputFormModel(">> ", spec, func(values []types.InputFieldValue) tea.Cmd {
+		req.Answer(values[0].Value)
+		return nil
+	})
+	form.SetCancelAction(func() tea.Cmd {
+		req.Cancel()
+		return nil
+	})
+	return ui.NewCreateCmd(types.PositionBotRight, form, true)
+}
+
+// joinedMsg reports that the chat was joined and can be opened.
+type joinedMsg struct {
+	server string
+	chat   string
+}
+
+func (m *MainModel) joinCmd(serverID, chatName, password string) tea.Cmd {
+	return func() tea.Msg {
+		if err := m.ss.Join(context.Background(), serverID, chatName, password); err != nil {
+			return ui.NewRequestErrCmd("cannot join "+chatName, err)()
+		}
+		return joinedMsg{server: serverID, chat: chatName}
+	}
+}
+
+func (m *MainModel) leaveCmd(serverID, chatName string) tea.Cmd {
+	return func() tea.Msg {
+		if err := m.ss.Leave(serverID, chatName); err != nil {
+			return ui.NewRequestErrCmd("cannot leave "+chatName, err)()
+		}
+		return nil
+	}
+}
+
+// chatPasswordCmd asks for the password of a protected chat, then joins it.
+func (m *MainModel) chatPasswordCmd(serverID, chatName string) tea.Cmd {
+	spec := []types.InputFieldSpec{
+		{
+			Name:   "password",
+			Title:  "Password of " + chatName,
+			Secret: true,
+		},
+	}
+	form := ui.NewInputFormModel(">> ", spec, func(values []types.InputFieldValue) tea.Cmd {
+		return m.joinCmd(serverID, chatName, values[0].Value)
+	})
+	return ui.NewCreateCmd(types.PositionBotRight, form, true)
+}
+
 func navCmd(pos types.Position, model tea.Model) tea.Cmd {
 	return func() tea.Msg {
 		return types.CreateWindowMsg{Pos: pos, Model: model, Focus: true}
@@ -67,8 +235,13 @@ func navCmd(pos types.Position, model tea.Model) tea.Cmd {
 }
 
 func (m *MainModel) Init() tea.Cmd {
-	return tea.Sequence(
-		navCmd(types.PositionTopLeft, uisrv.NewServer(config.GetServers())),
+	go m.ss.Poll(context.Background())
+
+	return tea.Batch(
+		navCmd(types.PositionTopLeft, uisrv.NewServer(m.ss)),
+		m.listenServer(),
+		m.listenChats(),
+		m.listenProbes(),
 	)
 }
 
@@ -82,13 +255,33 @@ func (m *MainModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.wm.Update(
 				types.CreateWindowMsg{
 					Pos:   types.PositionTopLeft,
-					Model: uisrv.NewServer(config.GetServers()),
+					Model: uisrv.NewServer(m.ss),
 					Focus: true,
 				},
 			)
 		case tea.KeyF3:
+			active := m.ss.ActiveID()
+			if active == "" {
+				return m, m.showToast("no active server, connect one in F2 Servers")
+			}
+			_, cmd := m.wm.Update(
+				types.CreateWindowMsg{
+					Pos:   types.PositionTopLeft,
+					Model: uichat.NewChatList(m.ss, active),
+					Focus: true,
+				},
+			)
+			return m, cmd
 		case tea.KeyF5:
-		case tea.KeyF10, tea.KeyCtrlC:
+			_, cmd := m.wm.Update(types.RefreshMsg{})
+			return m, cmd
+		case tea.KeyF10:
+			// say goodbye to every server before quitting
+			return m, func() tea.Msg {
+				m.ss.DisconnectAll()
+				return tea.Quit()
+			}
+		case tea.KeyCtrlC:
 			return m, tea.Quit
 		default:
 			_, cmd := m.wm.Update(msg)
@@ -102,8 +295,63 @@ func (m *MainModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			Height: m.height - types.HeaderHeight - types.FooterHeight,
 		})
 	case types.ServerMsg:
-		m.status = matchServerStatus(msg.Status)
-		m.server = msg.Server.Address
+		// the header renders from m.ss, the message only triggers redraw
+		cmds := []tea.Cmd{m.listenServer()}
+		if !msg.RetryAt.IsZero() {
+			m.tickID++
+			cmds = append(cmds, m.tick())
+		}
+		var pinErr *server.PinMismatchError
+		if errors.As(msg.Err, &pinErr) {
+			cmds = append(cmds, m.confirmPinCmd(msg.Server, pinErr))
+		}
+		if msg.Password != nil {
+			cmds = append(cmds, m.passwordCmd(msg.Password))
+		}
+		return m, tea.Batch(cmds...)
+	case types.ChatMsg:
+		// every open conversation picks the messages of its chat
+		_, cmd := m.wm.Update(types.BroadcastMsg{Msg: msg})
+		cmds := []tea.Cmd{cmd, m.listenChats()}
+		if msg.Err != nil {
+			cmds = append(cmds, m.showToast(msg.Err.Error()))
+		}
+		return m, tea.Batch(cmds...)
+	case types.OpenChatMsg:
+		if msg.Chat.Flags&chat.ProtectedFlag > 0 && !msg.Chat.Joined {
+			return m, m.chatPasswordCmd(msg.Server, msg.Chat.Name)
+		}
+		return m, m.joinCmd(msg.Server, msg.Chat.Name, "")
+	case joinedMsg:
+		// counted first, a conversation of the same chat it replaces must
+		// not leave it
+		m.open[openChat{msg.server, msg.chat}]++
+		conv := ui.NewConversation(m.ss, msg.server, msg.chat)
+		_, cmd := m.wm.Update(types.CreateWindowMsg{
+			Pos:   types.PositionTopRight,
+			Model: conv,
+			Focus: true,
+		})
+		return m, cmd
+	case types.ChatClosedMsg:
+		oc := openChat{msg.Server, msg.Chat}
+		if m.open[oc]--; m.open[oc] > 0 {
+			return m, nil
+		}
+		delete(m.open, oc)
+		return m, m.leaveCmd(msg.Server, msg.Chat)
+	case types.ErrMsg:
+		return m, m.showToast(msg.Text)
+	case toastExpiredMsg:
+		if msg.id == m.toastID {
+			m.toast = ""
+		}
+	case probeMsg:
+		return m, m.listenProbes()
+	case tickMsg:
+		if msg.id == m.tickID && m.retryPending() {
+			return m, m.tick()
+		}
 	default:
 		_, cmd := m.wm.Update(msg)
 		return m, cmd
@@ -112,17 +360,44 @@ func (m *MainModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
-func (m *MainModel) renderStatus() string {
-	color := color.GColorScheme.ServerStatus[m.status]
+// retryPending reports whether any connection waits for a reconnect.
+func (m *MainModel) retryPending() bool {
+	for _, ev := range m.ss.Connections() {
+		if time.Now().Before(ev.RetryAt) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MainModel) renderStatus(ev server.Event) string {
+	status := matchServerStatus(ev.Status)
+	color := color.GColorScheme.ServerStatus[status]
 	var text string
 
-	switch m.status {
+	switch status {
 	case "connected":
 		text = "Connected"
+		if ev.Latency > 0 {
+			text += fmt.Sprintf(" %dms", max(ev.Latency.Milliseconds(), 1))
+		}
 	case "connecting":
 		text = "Connecting"
+		if ev.Attempt > 0 {
+			text = fmt.Sprintf("Reconnecting (attempt %d", ev.Attempt)
+			if left := time.Until(ev.RetryAt); left > 0 {
+				text += fmt.Sprintf(", retry in %s", left.Round(time.Second))
+			}
+			text += ")"
+		}
+		if ev.Err != nil {
+			text += ": " + ev.Err.Error()
+		}
 	case "disconnected":
 		text = "Disconnected"
+		if ev.Err != nil {
+			text += ": " + ev.Err.Error()
+		}
 	}
 
 	return lipgloss.NewStyle().
@@ -130,20 +405,51 @@ func (m *MainModel) renderStatus() string {
 		Render("● " + text)
 }
 
+// renderConnections summarizes live connections besides the active one.
+func (m *MainModel) renderConnections(conns []server.Event, active string) string {
+	var items []string
+	for _, ev := range conns {
+		if ev.Server.ID == active || ev.Status == server.StatusDisconnected {
+			continue
+		}
+		color := color.GColorScheme.ServerStatus[matchServerStatus(ev.Status)]
+		dot := lipgloss.NewStyle().
+			Foreground(color.Text).
+			Render("●")
+		items = append(items, dot+" "+ev.Server.Name)
+	}
+
+	return lipgloss.NewStyle().
+		Foreground(color.GColorScheme.TextBase.Text).
+		Render(strings.Join(items, "  "))
+}
+
 func (m *MainModel) renderHeader() string {
+	conns := m.ss.Connections()
+	activeID := m.ss.ActiveID()
+	active := server.Event{Status: server.StatusDisconnected}
+	for _, ev := range conns {
+		if ev.Server.ID == activeID {
+			active = ev
+		}
+	}
+
 	var leftSide string
 	leftSide += lipgloss.NewStyle().
 		Bold(true).
 		Foreground(color.GColorScheme.AppName.Text).
 		Render("AndrewChat" + "  ")
 
-	if m.status == "connected" {
+	if active.Status == server.StatusConnected {
 		leftSide += lipgloss.NewStyle().
 			Foreground(color.GColorScheme.TextBase.Text).
-			Render("Server: " + m.server)
+			Render("Server: " + active.Server.Address)
 	}
 
-	status := m.renderStatus()
+	status := m.renderStatus(active)
+	if others := m.renderConnections(conns, activeID); others != "" {
+		status = others + "  " + status
+	}
 
 	space := m.width - lipgloss.Width(leftSide) - lipgloss.Width(status) - 1
 	if space < 0 {
@@ -157,7 +463,20 @@ func (m *MainModel) renderHeader() string {
 		Render(row)
 }
 
+func (m *MainModel) renderToast() string {
+	return lipgloss.NewStyle().
+		Width(m.width).
+		Align(lipgloss.Center).
+		Foreground(color.GColorScheme.Toast.Text).
+		Background(color.GColorScheme.Toast.Background).
+		Render(m.toast)
+}
+
 func (m *MainModel) renderFooter() string {
+	if m.toast != "" {
+		return m.renderToast()
+	}
+
 	keyStyle := lipgloss.NewStyle().
 		Bold(true).
 		Foreground(lipgloss.Color(color.GColorScheme.Fkey.Text))
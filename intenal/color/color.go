@@ -26,6 +26,9 @@ type ColorScheme struct {
 	ButtonBlurred *ColorFrame
 	Help          *ColorFrame
 	CursorHelp    *ColorFrame
+
+	// short lived notices like errors and "slow down"
+	Toast *ColorFrame
 }
 
 func PinkAndrewScheme() *ColorScheme {
@@ -42,6 +45,7 @@ func PinkAndrewScheme() *ColorScheme {
 		ButtonBlurred:   &ColorFrame{Text: lipgloss.Color("74")},
 		Help:            &ColorFrame{Text: lipgloss.Color("74")},
 		CursorHelp:      &ColorFrame{Text: lipgloss.Color("244")},
+		Toast:           &ColorFrame{Text: lipgloss.Color("232"), Background: lipgloss.Color("214")},
 		ServerStatus: map[string]*ColorFrame{
 			"connected":    {Text: lipgloss.Color("42")},
 			"connecting":   {Text: lipgloss.Color("214")},
@@ -65,6 +69,7 @@ func OceanScheme() *ColorScheme {
 		ButtonBlurred:   &ColorFrame{Text: lipgloss.Color("238")},
 		Help:            &ColorFrame{Text: lipgloss.Color("74")},
 		CursorHelp:      &ColorFrame{Text: lipgloss.Color("244")},
+		Toast:           &ColorFrame{Text: lipgloss.Color("232"), Background: lipgloss.Color("214")},
 		ServerStatus: map[string]*ColorFrame{
 			"connected":    {Text: lipgloss.Color("42")},
 			"connecting":   {Text: lipgloss.Color("226")},
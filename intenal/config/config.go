@@ -2,16 +2,29 @@ package config
 
 import (
 	"andrew_chat/intenal/domain"
+	"andrew_chat/intenal/ratelimit"
 	"encoding/json"
 	"errors"
 	"os"
+	"path/filepath"
+	"sync"
 )
 
 var configPath string
 var globalConfig *Config
 
+// connections update the config from their own goroutines
+var mu sync.Mutex
+
 type Config struct {
 	Servers []domain.Server `json:"servers"`
+	// proxy URL for all servers without their own, e.g. socks5://host:1080
+	Proxy string `json:"proxy,omitempty"`
+	// reachability polling of saved servers, defaults if zero
+	PollInterval    int `json:"poll_interval,omitempty"` // seconds
+	PollConcurrency int `json:"poll_concurrency,omitempty"`
+	// messages sent per server, default if unset
+	SendLimit *ratelimit.Limit `json:"send_limit,omitempty"`
 }
 
 func InitConfig(path string) {
@@ -31,6 +44,11 @@ func InitConfig(path string) {
 	globalConfig = &cfg
 }
 
+// Dir is where the config lives, other state files are kept next to it.
+func Dir() string {
+	return filepath.Dir(configPath)
+}
+
 func save() error {
 	b, err := json.MarshalIndent(globalConfig, "", "  ")
 	if err != nil {
@@ -41,6 +59,9 @@ func save() error {
 }
 
 func AddServer(server domain.Server) error {
+	mu.Lock()
+	defer mu.Unlock()
+
 	if server.ID == "" {
 		return errors.New("server id is empty")
 	}
@@ -57,6 +78,9 @@ func AddServer(server domain.Server) error {
 }
 
 func DeleteServer(id string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
 	for i, s := range globalConfig.Servers {
 		if s.ID == id {
 			globalConfig.Servers =
@@ -69,6 +93,9 @@ func DeleteServer(id string) error {
 }
 
 func UpdateServer(server domain.Server) error {
+	mu.Lock()
+	defer mu.Unlock()
+
 	for i, s := range globalConfig.Servers {
 		if s.ID == server.ID {
 			globalConfig.Servers[i] = server
@@ -79,7 +106,52 @@ func UpdateServer(server domain.Server) error {
 	return errors.New("server not found")
 }
 
+// PinServer sets the pinned certificate key of the server, empty removes it.
+func PinServer(id string, fingerprint string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for i, s := range globalConfig.Servers {
+		if s.ID == id {
+			globalConfig.Servers[i].PinnedSPKI = fingerprint
+			return save()
+		}
+	}
+
+	return errors.New("server not found")
+}
+
+func GetProxy() string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return globalConfig.Proxy
+}
+
+// GetPoll returns the polling interval in seconds and how many servers are
+// probed at once.
+func GetPoll() (int, int) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return globalConfig.PollInterval, globalConfig.PollConcurrency
+}
+
+// GetSendLimit returns the message limit per server, false if unset.
+func GetSendLimit() (ratelimit.Limit, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if globalConfig.SendLimit == nil {
+		return ratelimit.Limit{}, false
+	}
+	return *globalConfig.SendLimit, true
+}
+
 func GetServers() []domain.Server {
+	mu.Lock()
+	defer mu.Unlock()
+
 	servers := make([]domain.Server, len(globalConfig.Servers))
 	copy(servers, globalConfig.Servers)
 	return servers
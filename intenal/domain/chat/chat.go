@@ -1,5 +1,11 @@
 package chat
 
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
 type ChatFlags int
 
 const (
@@ -11,6 +17,16 @@ const (
 type Chat struct {
 	Name  string
 	Flags ChatFlags
+	// whether this client is a member
+	Joined bool
+	// role of the user in a group with an owner
+	Role Role
+	// seq of the last message
+	Seq uint64
+	// messages after the last read receipt of the user
+	Unread uint64
+	// when the last message was posted, zero if never
+	LastActivity time.Time
 }
 
 // Implements item.Item (bubbles)
@@ -34,5 +50,30 @@ func (c Chat) Description() string {
 		desc += "| PROTECTED"
 	}
 
+	if c.Role != RoleNone {
+		desc += " | " + strings.ToUpper(string(c.Role))
+	}
+
+	if c.Unread > 0 {
+		desc += fmt.Sprintf("  %d unread", c.Unread)
+	}
+	if !c.LastActivity.IsZero() {
+		desc += "  " + since(c.LastActivity)
+	}
+
 	return desc
 }
+
+// since formats the age of t coarsely, the way chat lists do.
+func since(t time.Time) string {
+	d := time.Since(t)
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d.Hours()))
+	}
+	return t.Local().Format("Jan 2")
+}
@@ -0,0 +1,48 @@
+package chat
+
+// DeliveryState of an outgoing message, in the order a message goes through
+// them. A pending message may fail instead.
+type DeliveryState int
+
+const (
+	// queued in the outbox, not acked by the server yet
+	Pending DeliveryState = iota
+	// acked by the server
+	Sent
+	// received by another member
+	Delivered
+	// seen by another member
+	Read
+	// refused by the server, it is not sent again
+	Failed
+)
+
+// Marker is shown next to the message: a clock, a check, a double check or
+// a cross. Read differs from Delivered by color only.
+func (s DeliveryState) Marker() string {
+	switch s {
+	case Pending:
+		return "◷"
+	case Sent:
+		return "✓"
+	case Failed:
+		return "✗"
+	}
+	return "✓✓"
+}
+
+func (s DeliveryState) String() string {
+	switch s {
+	case Pending:
+		return "pending"
+	case Sent:
+		return "sent"
+	case Delivered:
+		return "delivered"
+	case Read:
+		return "read"
+	case Failed:
+		return "failed"
+	}
+	return "unknown"
+}
@@ -0,0 +1,42 @@
+package chat
+
+import "time"
+
+// Message posted in a chat.
+type Message struct {
+	ID     string
+	ChatID string
+	Author string
+	Text   string
+	// position in the chat assigned by the server, 0 until published
+	Seq uint64
+	// when the server published the message, zero until then
+	ServerTime time.Time
+	// when the author wrote it, by the clock of the author
+	ClientTime time.Time
+	// ID of the message this one answers, empty if none
+	ReplyTo  string
+	EditedAt time.Time
+	Deleted  bool
+	// written by the server, e.g. a membership change; it has no author
+	System bool
+}
+
+// Published reports whether the server assigned the message its place.
+func (m Message) Published() bool {
+	return m.Seq > 0
+}
+
+// Edited reports whether the text was changed after posting.
+func (m Message) Edited() bool {
+	return !m.EditedAt.IsZero()
+}
+
+// Time is when the message was posted: the server time once published, the
+// client time before.
+func (m Message) Time() time.Time {
+	if !m.ServerTime.IsZero() {
+		return m.ServerTime
+	}
+	return m.ClientTime
+}
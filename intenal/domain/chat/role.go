@@ -0,0 +1,22 @@
+package chat
+
+// Role of the user in a group with an owner, empty otherwise.
+type Role string
+
+const (
+	RoleNone   Role = ""
+	RoleMember Role = "member"
+	RoleAdmin  Role = "admin"
+	RoleOwner  Role = "owner"
+)
+
+// CanManage reports whether the role may invite and remove members. The
+// server has the last word: nobody removes an equal or higher role.
+func (r Role) CanManage() bool {
+	return r == RoleOwner || r == RoleAdmin
+}
+
+// CanChangeRoles reports whether the role may promote and demote members.
+func (r Role) CanChangeRoles() bool {
+	return r == RoleOwner
+}
@@ -0,0 +1,163 @@
+package chat
+
+import (
+	"sort"
+	"sync"
+)
+
+// Store keeps the messages of one chat in memory, in the order of the chat.
+// Published messages are ordered by Seq; messages not published yet follow
+// them in the order they were written. Messages are identified by ID, adding
+// one again updates it in place, so echoes, resends and history fetches may
+// overlap and arrive in any order.
+type Store struct {
+	mu   sync.Mutex
+	chat string
+	msgs []Message
+	// index of each message in msgs, by ID
+	byID map[string]int
+}
+
+func NewStore(chatID string) *Store {
+	return &Store{
+		chat: chatID,
+		byID: make(map[string]int),
+	}
+}
+
+func (s *Store) ChatID() string {
+	return s.chat
+}
+
+// Add merges msg into the store. It returns false if msg was known and
+// nothing changed.
+func (s *Store) Add(msg Message) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if i, ok := s.byID[msg.ID]; ok {
+		merged := merge(s.msgs[i], msg)
+		if merged == s.msgs[i] {
+			return false
+		}
+		s.removeLocked(i)
+		msg = merged
+	}
+
+	i := sort.Search(len(s.msgs), func(i int) bool {
+		return before(msg, s.msgs[i])
+	})
+	s.msgs = append(s.msgs, Message{})
+	copy(s.msgs[i+1:], s.msgs[i:])
+	s.msgs[i] = msg
+	s.reindexLocked(i)
+	return true
+}
+
+// Remove drops the message with the ID, e.g. a message the server rejected.
+func (s *Store) Remove(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.byID[id]
+	if !ok {
+		return false
+	}
+	s.removeLocked(i)
+	return true
+}
+
+func (s *Store) Get(id string) (Message, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.byID[id]
+	if !ok {
+		return Message{}, false
+	}
+	return s.msgs[i], true
+}
+
+// Messages returns a copy of the messages in chat order.
+func (s *Store) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msgs := make([]Message, len(s.msgs))
+	copy(msgs, s.msgs)
+	return msgs
+}
+
+// LastSeq returns the Seq of the last published message, 0 if none.
+func (s *Store) LastSeq() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := len(s.msgs) - 1; i >= 0; i-- {
+		if s.msgs[i].Published() {
+			return s.msgs[i].Seq
+		}
+	}
+	return 0
+}
+
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.msgs)
+}
+
+func (s *Store) removeLocked(i int) {
+	delete(s.byID, s.msgs[i].ID)
+	s.msgs = append(s.msgs[:i], s.msgs[i+1:]...)
+	s.reindexLocked(i)
+}
+
+// reindexLocked updates byID for the messages from i on.
+func (s *Store) reindexLocked(from int) {
+	for i := from; i < len(s.msgs); i++ {
+		s.byID[s.msgs[i].ID] = i
+	}
+}
+
+// before reports whether a comes before b in the chat. Ties keep the
+// message added first in front.
+func before(a, b Message) bool {
+	switch {
+	case a.Published() && b.Published():
+		return a.Seq < b.Seq
+	case a.Published() != b.Published():
+		return a.Published()
+	}
+	return a.ClientTime.Before(b.ClientTime)
+}
+
+// merge combines two copies of the same message. What the server assigned
+// is never lost to an older copy, edits and deletion only move forward.
+func merge(old, msg Message) Message {
+	if msg.ChatID == "" {
+		msg.ChatID = old.ChatID
+	}
+	if msg.Author == "" {
+		msg.Author = old.Author
+	}
+	if msg.Seq == 0 {
+		msg.Seq = old.Seq
+	}
+	if msg.ServerTime.IsZero() {
+		msg.ServerTime = old.ServerTime
+	}
+	if msg.ClientTime.IsZero() {
+		msg.ClientTime = old.ClientTime
+	}
+	if msg.ReplyTo == "" {
+		msg.ReplyTo = old.ReplyTo
+	}
+	// an older edit, or a stale copy from before it, keeps the newer text
+	if old.EditedAt.After(msg.EditedAt) {
+		msg.Text = old.Text
+		msg.EditedAt = old.EditedAt
+	}
+	msg.Deleted = msg.Deleted || old.Deleted
+	return msg
+}
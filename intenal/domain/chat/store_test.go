@@ -0,0 +1,162 @@
+package chat
+
+import (
+	"testing"
+	"time"
+)
+
+var t0 = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// ids returns the IDs of the messages of s in chat order.
+func ids(s *Store) []string {
+	msgs := s.Messages()
+	ids := make([]string, len(msgs))
+	for i, m := range msgs {
+		ids[i] = m.ID
+	}
+	return ids
+}
+
+func expectOrder(t *testing.T, s *Store, want ...string) {
+	t.Helper()
+	got := ids(s)
+	if len(got) != len(want) {
+		t.Fatalf("order = %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("order = %v, want %v", got, want)
+		}
+	}
+}
+
+func get(t *testing.T, s *Store, id string) Message {
+	t.Helper()
+	m, ok := s.Get(id)
+	if !ok {
+		t.Fatalf("message %s missing", id)
+	}
+	return m
+}
+
+func TestStoreOutOfOrder(t *testing.T) {
+	s := NewStore("general")
+	for _, seq := range []uint64{3, 1, 5, 2, 4} {
+		s.Add(Message{ID: string(rune('a' + seq - 1)), Seq: seq})
+	}
+	expectOrder(t, s, "a", "b", "c", "d", "e")
+}
+
+func TestStoreDuplicates(t *testing.T) {
+	s := NewStore("general")
+	msg := Message{ID: "a", Seq: 1, Text: "hi", ServerTime: t0}
+	if !s.Add(msg) {
+		t.Fatal("first add reported no change")
+	}
+	if s.Add(msg) {
+		t.Error("same message again reported a change")
+	}
+	if s.Len() != 1 {
+		t.Errorf("len = %d, want 1", s.Len())
+	}
+}
+
+func TestStoreEchoOverPending(t *testing.T) {
+	s := NewStore("general")
+	s.Add(Message{ID: "mine", ChatID: "general", Author: "al", Text: "hi", ClientTime: t0})
+	s.Add(Message{ID: "b", Seq: 2})
+
+	// the echo of the server does not carry the client time
+	s.Add(Message{ID: "mine", Text: "hi", Seq: 1, ServerTime: t0.Add(time.Second)})
+
+	m := get(t, s, "mine")
+	if m.Seq != 1 || !m.ServerTime.Equal(t0.Add(time.Second)) {
+		t.Errorf("seq %d, server time %v: server fields lost", m.Seq, m.ServerTime)
+	}
+	if !m.ClientTime.Equal(t0) || m.Author != "al" || m.ChatID != "general" {
+		t.Errorf("pending fields lost: %+v", m)
+	}
+	expectOrder(t, s, "mine", "b")
+
+	// a resend of the pending copy does not undo the echo
+	s.Add(Message{ID: "mine", Text: "hi", ClientTime: t0})
+	if m := get(t, s, "mine"); m.Seq != 1 || m.ServerTime.IsZero() {
+		t.Errorf("stale pending copy overwrote the echo: %+v", m)
+	}
+	expectOrder(t, s, "mine", "b")
+}
+
+func TestStoreStaleEdit(t *testing.T) {
+	s := NewStore("general")
+	s.Add(Message{ID: "a", Seq: 1, Text: "first"})
+	s.Add(Message{ID: "a", Seq: 1, Text: "third", EditedAt: t0.Add(2 * time.Minute)})
+	s.Add(Message{ID: "a", Seq: 1, Text: "second", EditedAt: t0.Add(time.Minute)})
+	s.Add(Message{ID: "a", Seq: 1, Text: "first"})
+
+	m := get(t, s, "a")
+	if m.Text != "third" || !m.Edited() {
+		t.Errorf("text %q, edited %v: want the newest edit", m.Text, m.Edited())
+	}
+}
+
+func TestStoreDeletedSticks(t *testing.T) {
+	s := NewStore("general")
+	s.Add(Message{ID: "a", Seq: 1, Text: "oops"})
+	s.Add(Message{ID: "a", Seq: 1, Deleted: true})
+	s.Add(Message{ID: "a", Seq: 1, Text: "oops"})
+
+	if !get(t, s, "a").Deleted {
+		t.Error("an older copy restored a deleted message")
+	}
+}
+
+func TestStorePendingLast(t *testing.T) {
+	s := NewStore("general")
+	s.Add(Message{ID: "p2", ClientTime: t0.Add(2 * time.Second)})
+	s.Add(Message{ID: "b", Seq: 2})
+	s.Add(Message{ID: "p1", ClientTime: t0.Add(time.Second)})
+	s.Add(Message{ID: "a", Seq: 1})
+	expectOrder(t, s, "a", "b", "p1", "p2")
+
+	// published, p2 takes its place among the others
+	s.Add(Message{ID: "p2", Seq: 3})
+	expectOrder(t, s, "a", "b", "p2", "p1")
+}
+
+func TestStoreLastSeq(t *testing.T) {
+	s := NewStore("general")
+	if seq := s.LastSeq(); seq != 0 {
+		t.Errorf("empty store: last seq = %d", seq)
+	}
+	s.Add(Message{ID: "a", Seq: 4})
+	s.Add(Message{ID: "b", Seq: 9})
+	s.Add(Message{ID: "p", ClientTime: t0})
+	if seq := s.LastSeq(); seq != 9 {
+		t.Errorf("last seq = %d, want 9", seq)
+	}
+}
+
+func TestStoreRemove(t *testing.T) {
+	s := NewStore("general")
+	s.Add(Message{ID: "a", Seq: 1})
+	s.Add(Message{ID: "b", Seq: 2})
+	s.Add(Message{ID: "c", Seq: 3})
+
+	if !s.Remove("b") {
+		t.Fatal("remove of a stored message failed")
+	}
+	if s.Remove("b") {
+		t.Error("second remove reported success")
+	}
+	if _, ok := s.Get("b"); ok {
+		t.Error("removed message still found")
+	}
+	expectOrder(t, s, "a", "c")
+
+	// the index of the following messages is updated
+	if m := get(t, s, "c"); m.Seq != 3 {
+		t.Errorf("c has seq %d", m.Seq)
+	}
+	s.Add(Message{ID: "b", Seq: 2})
+	expectOrder(t, s, "a", "b", "c")
+}
@@ -1,6 +1,13 @@
 package domain
 
-import "time"
+import (
+	"strings"
+	"time"
+)
+
+// Address prefix of servers listening on a unix domain socket,
+// e.g. unix:///run/andrewd.sock
+const UnixScheme = "unix://"
 
 // represent selectable server
 type Server struct {
@@ -16,6 +23,44 @@ type Server struct {
 	Active      bool      `json:"active"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
+
+	// TLS settings, used by TLS protocols only
+	CAFile             string `json:"ca_file,omitempty"`
+	CertFile           string `json:"cert_file,omitempty"`
+	KeyFile            string `json:"key_file,omitempty"`
+	ServerName         string `json:"server_name,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+	// accept a certificate that does not verify, e.g. self-signed, on the
+	// first connect and pin its key
+	TrustOnFirstUse bool `json:"trust_on_first_use,omitempty"`
+	// key trusted on first use, see server.PinMismatchError
+	PinnedSPKI string `json:"pinned_spki,omitempty"`
+
+	// SSH settings, used by protocol "ssh" only. Address and Port locate
+	// the SSH server, Tunnel the chat server as seen from it.
+	SSHKeyFile     string `json:"ssh_key_file,omitempty"`
+	KnownHostsFile string `json:"known_hosts_file,omitempty"`
+	Tunnel         string `json:"tunnel,omitempty"`
+
+	// WebSocket endpoint, used by protocols "http" and "https"
+	WSPath string `json:"ws_path,omitempty"`
+
+	// socks5:// or http:// proxy URL, "direct" bypasses the global proxy
+	Proxy string `json:"proxy,omitempty"`
+
+	// stream compression: "flate" or "gzip" offers only that algorithm,
+	// "off" disables it, empty offers all the client supports
+	Compression string `json:"compression,omitempty"`
+}
+
+// UnixSocket returns the socket path of a unix domain socket address.
+func (s Server) UnixSocket() (string, bool) {
+	return strings.CutPrefix(s.Address, UnixScheme)
+}
+
+// UsesTLS reports whether the connection is wrapped in TLS.
+func (s Server) UsesTLS() bool {
+	return s.Protocol == "https" || s.Protocol == "tls"
 }
 
 // Implements item.Item (bubbles)
@@ -28,5 +73,8 @@ func (s Server) Title() string {
 }
 
 func (s Server) Description() string {
+	if path, ok := s.UnixSocket(); ok {
+		return "unix socket " + path
+	}
 	return s.Address
 }
@@ -0,0 +1,141 @@
+package protocol
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// Frame layout:
+//
+//	+---------+---------+------+----------------+-----------+
+//	| magic 2 | version | type | length (BE) 4  | payload   |
+//	+---------+---------+------+----------------+-----------+
+const (
+	magic0     = 'A'
+	magic1     = 'C'
+	headerSize = 8
+
+	// MaxPayload bounds the memory a single frame can make the decoder
+	// allocate.
+	MaxPayload = 1 << 20
+)
+
+var (
+	ErrBadMagic           = errors.New("protocol: bad magic")
+	ErrUnsupportedVersion = errors.New("protocol: unsupported version")
+	ErrFrameTooLarge      = errors.New("protocol: frame too large")
+)
+
+// Encoder writes frames to w. Every frame is passed to w in a single Write
+// call, so frames of concurrent encoders never interleave on a net.Conn.
+// That does not hold once Compress is called.
+type Encoder struct {
+	w   io.Writer
+	buf []byte
+	// header version, MinVersion until SetVersion
+	version byte
+
+	// set by Compress
+	conn io.Writer
+	zw   flushWriter
+	// frame bytes encoded and bytes written to conn, counted once compressed
+	raw  atomic.Int64
+	wire atomic.Int64
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w, conn: w, version: MinVersion}
+}
+
+// SetVersion makes e write the version the handshake agreed on.
+func (e *Encoder) SetVersion(v int) error {
+	if v < MinVersion || v > Version {
+		return fmt.Errorf("%w: %d", ErrUnsupportedVersion, v)
+	}
+	e.version = byte(v)
+	return nil
+}
+
+func (e *Encoder) Encode(f Frame) error {
+	if len(f.Payload) > MaxPayload {
+		return ErrFrameTooLarge
+	}
+
+	n := headerSize + len(f.Payload)
+	if cap(e.buf) < n {
+		e.buf = make([]byte, n)
+	}
+	buf := e.buf[:n]
+
+	buf[0] = magic0
+	buf[1] = magic1
+	buf[2] = e.version
+	buf[3] = byte(f.Type)
+	binary.BigEndian.PutUint32(buf[4:headerSize], uint32(len(f.Payload)))
+	copy(buf[headerSize:], f.Payload)
+
+	if _, err := e.w.Write(buf); err != nil {
+		return err
+	}
+	if e.zw == nil {
+		return nil
+	}
+	e.raw.Add(int64(n))
+	return e.zw.Flush()
+}
+
+// Decoder reads frames from r. Short reads are retried until a whole frame is
+// available; a stream that ends inside a frame yields io.ErrUnexpectedEOF.
+type Decoder struct {
+	r      *bufio.Reader
+	header [headerSize]byte
+
+	// the stream, r reads decompressed data from it after Decompress
+	conn       *bufio.Reader
+	compressed bool
+	// frame bytes decoded and bytes read from conn, counted once compressed
+	raw  atomic.Int64
+	wire atomic.Int64
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	br := bufio.NewReader(r)
+	return &Decoder{r: br, conn: br}
+}
+
+func (d *Decoder) Decode() (Frame, error) {
+	if _, err := io.ReadFull(d.r, d.header[:]); err != nil {
+		return Frame{}, err
+	}
+
+	if d.header[0] != magic0 || d.header[1] != magic1 {
+		return Frame{}, ErrBadMagic
+	}
+	if d.header[2] < MinVersion || d.header[2] > Version {
+		return Frame{}, fmt.Errorf("%w: %d", ErrUnsupportedVersion, d.header[2])
+	}
+
+	size := binary.BigEndian.Uint32(d.header[4:])
+	if size > MaxPayload {
+		return Frame{}, ErrFrameTooLarge
+	}
+
+	f := Frame{
+		Type:    Type(d.header[3]),
+		Payload: make([]byte, size),
+	}
+	if _, err := io.ReadFull(d.r, f.Payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return Frame{}, err
+	}
+	if d.compressed {
+		d.raw.Add(int64(headerSize + size))
+	}
+	return f, nil
+}
@@ -0,0 +1,224 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+var at = time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+
+// one payload of every frame type
+var samples = []struct {
+	t       Type
+	payload any
+}{
+	{TypeHello, &Hello{Version: Version, Agent: "test", Caps: Caps{CapReceipts}, Compress: Compressions}},
+	{TypeAuth, &Auth{ID: "1", Username: "al", Password: "secret"}},
+	{TypeJoin, &Join{ID: "2", Chat: "general", Password: "pw"}},
+	{TypeLeave, &Leave{ID: "3", Chat: "general"}},
+	{TypeMessage, &Message{ID: "4", Chat: "general", Author: "al", Text: "hi", Seq: 7, Time: at, Sent: at, ReplyTo: "3"}},
+	{TypeAck, &Ack{ID: "4", Seq: 7}},
+	{TypeError, &Error{ID: "5", Code: CodeRateLimited, Text: "slow down", RetryAfterMs: 250}},
+	{TypePing, &Ping{Time: at}},
+	{TypePong, &Pong{Time: at}},
+	{TypeReceipt, &Receipt{Chat: "general", Seq: 7, State: ReceiptRead, User: "bo"}},
+	{TypeGoodbye, &Goodbye{Reason: "quit"}},
+	{TypeList, &List{ID: "6"}},
+	{TypeChats, &Chats{ID: "6", Chats: []ChatInfo{{Name: "general", Group: true, Seq: 7, Unread: 2, LastActivity: at}}}},
+	{TypeChatPassword, &ChatPassword{ID: "7", Chat: "team", Password: "new"}},
+	{TypeMember, &Member{ID: "8", Chat: "team", User: "bo", Action: MemberPromote}},
+}
+
+func encodeSamples(t *testing.T, enc *Encoder) {
+	t.Helper()
+	for _, s := range samples {
+		f, err := NewFrame(s.t, s.payload)
+		if err != nil {
+			t.Fatalf("new %s frame: %v", s.t, err)
+		}
+		if err := enc.Encode(f); err != nil {
+			t.Fatalf("encode %s: %v", s.t, err)
+		}
+	}
+}
+
+func decodeSamples(t *testing.T, dec *Decoder) {
+	t.Helper()
+	for _, s := range samples {
+		f, err := dec.Decode()
+		if err != nil {
+			t.Fatalf("decode %s: %v", s.t, err)
+		}
+		if f.Type != s.t {
+			t.Fatalf("type = %s, want %s", f.Type, s.t)
+		}
+		got := reflect.New(reflect.TypeOf(s.payload).Elem()).Interface()
+		if err := f.Decode(got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, s.payload) {
+			t.Errorf("%s payload = %+v, want %+v", s.t, got, s.payload)
+		}
+	}
+}
+
+// expectEOF checks that a stream ending between frames ends cleanly.
+func expectEOF(t *testing.T, dec *Decoder) {
+	t.Helper()
+	if _, err := dec.Decode(); err != io.EOF {
+		t.Errorf("decode past the end: err = %v, want io.EOF", err)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	encodeSamples(t, NewEncoder(&buf))
+	dec := NewDecoder(&buf)
+	decodeSamples(t, dec)
+	expectEOF(t, dec)
+}
+
+func TestPartialReads(t *testing.T) {
+	var buf bytes.Buffer
+	encodeSamples(t, NewEncoder(&buf))
+	dec := NewDecoder(iotest.OneByteReader(&buf))
+	decodeSamples(t, dec)
+	expectEOF(t, dec)
+}
+
+func TestTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	f, _ := NewFrame(TypeMessage, Message{ID: "1", Chat: "general", Text: "hello"})
+	if err := NewEncoder(&buf).Encode(f); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, cut := range []int{3, headerSize + 1, buf.Len() - 1} {
+		dec := NewDecoder(bytes.NewReader(buf.Bytes()[:cut]))
+		if _, err := dec.Decode(); err != io.ErrUnexpectedEOF {
+			t.Errorf("cut at %d: err = %v, want io.ErrUnexpectedEOF", cut, err)
+		}
+	}
+}
+
+func header(m0, m1, version byte, size uint32) []byte {
+	h := []byte{m0, m1, version, byte(TypePing), 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(h[4:], size)
+	return h
+}
+
+func TestBadHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+		want   error
+	}{
+		{"magic", header('X', magic1, Version, 0), ErrBadMagic},
+		{"old version", header(magic0, magic1, MinVersion-1, 0), ErrUnsupportedVersion},
+		{"new version", header(magic0, magic1, Version+1, 0), ErrUnsupportedVersion},
+		{"size", header(magic0, magic1, Version, MaxPayload+1), ErrFrameTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewDecoder(bytes.NewReader(tt.header)).Decode()
+			if !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVersions(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	dec := NewDecoder(&buf)
+	ping, _ := NewFrame(TypePing, Ping{Time: at})
+
+	// before the handshake every peer must be able to read the frames
+	if err := enc.Encode(ping); err != nil {
+		t.Fatal(err)
+	}
+	if v := buf.Bytes()[2]; v != MinVersion {
+		t.Errorf("header version before SetVersion = %d, want %d", v, MinVersion)
+	}
+	if _, err := dec.Decode(); err != nil {
+		t.Fatal(err)
+	}
+
+	for v := MinVersion; v <= Version; v++ {
+		if err := enc.SetVersion(v); err != nil {
+			t.Fatalf("set version %d: %v", v, err)
+		}
+		if err := enc.Encode(ping); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.Bytes()[2]; int(got) != v {
+			t.Errorf("header version = %d, want %d", got, v)
+		}
+		if _, err := dec.Decode(); err != nil {
+			t.Errorf("decode version %d: %v", v, err)
+		}
+	}
+
+	if err := enc.SetVersion(Version + 1); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("set version %d: err = %v", Version+1, err)
+	}
+}
+
+func TestEncodeTooLarge(t *testing.T) {
+	f := Frame{Type: TypeMessage, Payload: make([]byte, MaxPayload+1)}
+	if err := NewEncoder(io.Discard).Encode(f); err != ErrFrameTooLarge {
+		t.Errorf("err = %v, want ErrFrameTooLarge", err)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	for _, algo := range Compressions {
+		t.Run(algo, func(t *testing.T) {
+			var buf bytes.Buffer
+			enc := NewEncoder(&buf)
+			dec := NewDecoder(iotest.OneByteReader(&buf))
+
+			// the handshake frames stay uncompressed
+			hello, _ := NewFrame(TypeHello, Hello{Version: Version})
+			if err := enc.Encode(hello); err != nil {
+				t.Fatal(err)
+			}
+			if err := enc.Compress(algo); err != nil {
+				t.Fatal(err)
+			}
+			encodeSamples(t, enc)
+
+			if f, err := dec.Decode(); err != nil || f.Type != TypeHello {
+				t.Fatalf("decode hello: %v %v", f.Type, err)
+			}
+			if err := dec.Decompress(algo); err != nil {
+				t.Fatal(err)
+			}
+			decodeSamples(t, dec)
+
+			sent, received := enc.Stats(), dec.Stats()
+			if sent.Raw == 0 || sent.Raw != received.Raw {
+				t.Errorf("raw bytes sent %d, received %d", sent.Raw, received.Raw)
+			}
+			if sent.Wire != received.Wire {
+				t.Errorf("wire bytes sent %d, received %d", sent.Wire, received.Wire)
+			}
+		})
+	}
+}
+
+func TestUnknownCompression(t *testing.T) {
+	if err := NewEncoder(io.Discard).Compress("zstd"); err == nil {
+		t.Error("encoder accepted an unknown algorithm")
+	}
+	if err := NewDecoder(bytes.NewReader(nil)).Decompress("zstd"); err == nil {
+		t.Error("decoder accepted an unknown algorithm")
+	}
+}
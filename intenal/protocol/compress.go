@@ -0,0 +1,144 @@
+package protocol
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// Stream compression algorithms, offered in the hello of the client when
+// CapCompression is negotiated.
+const (
+	CompressFlate = "flate"
+	CompressGzip  = "gzip"
+)
+
+// Compressions lists the supported algorithms, preferred first.
+var Compressions = []string{CompressFlate, CompressGzip}
+
+// flushWriter compresses into w; every frame is flushed so that it is not
+// held back waiting for more data.
+type flushWriter interface {
+	io.Writer
+	Flush() error
+}
+
+func newCompressor(algo string, w io.Writer) (flushWriter, error) {
+	switch algo {
+	case CompressFlate:
+		return flate.NewWriter(w, flate.DefaultCompression)
+	case CompressGzip:
+		return gzip.NewWriter(w), nil
+	}
+	return nil, fmt.Errorf("protocol: unknown compression %q", algo)
+}
+
+// countingWriter counts the bytes that reach the stream.
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// countingReader counts the bytes taken from the stream. It keeps the
+// io.ByteReader of bufio.Reader, so decompressors do not read ahead of the
+// compressed data.
+type countingReader struct {
+	r *bufio.Reader
+	n *atomic.Int64
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+func (c countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n.Add(1)
+	}
+	return b, err
+}
+
+// lazyReader opens the decompressor on first read: gzip.NewReader reads the
+// header, which the peer sends with its first frame only.
+type lazyReader struct {
+	open func() (io.Reader, error)
+	r    io.Reader
+}
+
+func (l *lazyReader) Read(p []byte) (int, error) {
+	if l.r == nil {
+		r, err := l.open()
+		if err != nil {
+			return 0, err
+		}
+		l.r = r
+	}
+	return l.r.Read(p)
+}
+
+// Compress makes e compress all frames after the ones written so far with
+// algo. Compressed frames take more than one Write call, so concurrent
+// Encode calls must be serialized by the caller.
+func (e *Encoder) Compress(algo string) error {
+	zw, err := newCompressor(algo, countingWriter{w: e.conn, n: &e.wire})
+	if err != nil {
+		return err
+	}
+	e.zw = zw
+	e.w = zw
+	return nil
+}
+
+// Decompress makes d decompress all frames after the ones read so far with
+// algo.
+func (d *Decoder) Decompress(algo string) error {
+	src := countingReader{r: d.conn, n: &d.wire}
+
+	var open func() (io.Reader, error)
+	switch algo {
+	case CompressFlate:
+		open = func() (io.Reader, error) { return flate.NewReader(src), nil }
+	case CompressGzip:
+		open = func() (io.Reader, error) { return gzip.NewReader(src) }
+	default:
+		return fmt.Errorf("protocol: unknown compression %q", algo)
+	}
+	d.r = bufio.NewReader(&lazyReader{open: open})
+	d.compressed = true
+	return nil
+}
+
+// Stats counts the bytes of frames passed through a compressed encoder or
+// decoder: Raw before compression, Wire on the stream.
+type Stats struct {
+	Raw  int64
+	Wire int64
+}
+
+// Ratio is Wire/Raw, 1 when nothing was compressed yet.
+func (s Stats) Ratio() float64 {
+	if s.Raw == 0 {
+		return 1
+	}
+	return float64(s.Wire) / float64(s.Raw)
+}
+
+func (e *Encoder) Stats() Stats {
+	return Stats{Raw: e.raw.Load(), Wire: e.wire.Load()}
+}
+
+func (d *Decoder) Stats() Stats {
+	return Stats{Raw: d.raw.Load(), Wire: d.wire.Load()}
+}
@@ -0,0 +1,296 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Version of the wire format written by this package. Peers agree on the
+// lower of their versions, down to MinVersion. Frames are written at
+// MinVersion until the hellos are exchanged, so that every peer can read
+// the hello of every other.
+const (
+	Version    = 1
+	MinVersion = 1
+)
+
+type Type uint8
+
+const (
+	TypeHello Type = iota + 1
+	TypeAuth
+	TypeJoin
+	TypeLeave
+	TypeMessage
+	TypeAck
+	TypeError
+	TypePing
+	TypePong
+	TypeReceipt
+	TypeGoodbye
+	TypeList
+	TypeChats
+	TypeChatPassword
+	TypeMember
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeHello:
+		return "hello"
+	case TypeAuth:
+		return "auth"
+	case TypeJoin:
+		return "join"
+	case TypeLeave:
+		return "leave"
+	case TypeMessage:
+		return "message"
+	case TypeAck:
+		return "ack"
+	case TypeError:
+		return "error"
+	case TypePing:
+		return "ping"
+	case TypePong:
+		return "pong"
+	case TypeReceipt:
+		return "receipt"
+	case TypeGoodbye:
+		return "goodbye"
+	case TypeList:
+		return "list"
+	case TypeChats:
+		return "chats"
+	case TypeChatPassword:
+		return "chat_password"
+	case TypeMember:
+		return "member"
+	}
+	return fmt.Sprintf("type(%d)", t)
+}
+
+// Frame is a single unit on the wire. Payload holds the JSON encoding of
+// one of the payload structs below, matching Type.
+type Frame struct {
+	Type    Type
+	Payload []byte
+}
+
+func NewFrame(t Type, payload any) (Frame, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return Frame{}, err
+	}
+	return Frame{Type: t, Payload: b}, nil
+}
+
+func (f Frame) Decode(v any) error {
+	if err := json.Unmarshal(f.Payload, v); err != nil {
+		return fmt.Errorf("decode %s payload: %w", f.Type, err)
+	}
+	return nil
+}
+
+// =============================================================================
+// Payloads
+// =============================================================================
+
+// First frame of both sides. The client lists the capabilities it supports,
+// the server answers with the negotiated version and the subset it supports
+// too.
+type Hello struct {
+	Version int    `json:"version"`
+	Agent   string `json:"agent"`
+	Caps    Caps   `json:"caps,omitempty"`
+	// compression algorithms offered by the client, preferred first; the
+	// server answers with the one it picked. Frames following the auth ack
+	// are compressed in both directions.
+	Compress []string `json:"compress,omitempty"`
+}
+
+// Optional features. A feature is used only if both sides advertise it.
+const (
+	CapReceipts    = "receipts"
+	CapReactions   = "reactions"
+	CapThreads     = "threads"
+	CapE2E         = "e2e"
+	CapCompression = "compression"
+)
+
+type Caps []string
+
+func (c Caps) Has(name string) bool {
+	for _, v := range c {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Intersect returns the capabilities present in both c and other.
+func (c Caps) Intersect(other Caps) Caps {
+	var both Caps
+	for _, v := range c {
+		if other.Has(v) && !both.Has(v) {
+			both = append(both, v)
+		}
+	}
+	return both
+}
+
+type Auth struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Password string `json:"password,omitempty"`
+}
+
+type Join struct {
+	ID       string `json:"id"`
+	Chat     string `json:"chat"`
+	Password string `json:"password,omitempty"`
+}
+
+// ChatPassword sets the password of a chat, empty removes it. Only the owner
+// of the chat may send it, members stay joined.
+type ChatPassword struct {
+	ID       string `json:"id"`
+	Chat     string `json:"chat"`
+	Password string `json:"password,omitempty"`
+}
+
+// Roles of the members of a group with an owner, highest first.
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+// Member actions: invite adds User to the group, kick removes it, promote
+// and demote move it between member and admin.
+const (
+	MemberInvite  = "invite"
+	MemberKick    = "kick"
+	MemberPromote = "promote"
+	MemberDemote  = "demote"
+)
+
+// Member changes the membership of User in a group. The server checks the
+// role of the sender and announces the change in the chat.
+type Member struct {
+	ID     string `json:"id"`
+	Chat   string `json:"chat"`
+	User   string `json:"user"`
+	Action string `json:"action"`
+}
+
+// Leave asks to leave a chat. Sent by the server, it tells that the client
+// was removed from the chat.
+type Leave struct {
+	ID   string `json:"id"`
+	Chat string `json:"chat"`
+}
+
+type Message struct {
+	ID     string `json:"id"`
+	Chat   string `json:"chat"`
+	Author string `json:"author,omitempty"`
+	Text   string `json:"text"`
+	Seq    uint64 `json:"seq,omitempty"`
+	// set by the server when it publishes the message
+	Time time.Time `json:"time"`
+	// set by the client when the message is written
+	Sent    time.Time `json:"sent,omitempty"`
+	ReplyTo string    `json:"reply_to,omitempty"`
+	// written by the server, e.g. to announce membership changes
+	System bool `json:"system,omitempty"`
+}
+
+// Ack confirms the request or message with the same ID.
+type Ack struct {
+	ID  string `json:"id"`
+	Seq uint64 `json:"seq,omitempty"`
+}
+
+// Error rejects the request or message with the same ID. ID is empty for
+// errors not bound to a request.
+type Error struct {
+	ID   string `json:"id,omitempty"`
+	Code string `json:"code"`
+	Text string `json:"text"`
+	// set with CodeRateLimited: when a resend can succeed
+	RetryAfterMs int64 `json:"retry_after_ms,omitempty"`
+}
+
+func (e Error) Error() string {
+	return e.Code + ": " + e.Text
+}
+
+const (
+	CodeBadRequest   = "bad_request"
+	CodeUnauthorized = "unauthorized"
+	CodeNotFound     = "not_found"
+	CodeForbidden    = "forbidden"
+	CodeInternal     = "internal"
+	CodeRateLimited  = "rate_limited"
+)
+
+// List asks for the chats on the server, answered by Chats with the same
+// ID.
+type List struct {
+	ID string `json:"id"`
+}
+
+type Chats struct {
+	ID    string     `json:"id"`
+	Chats []ChatInfo `json:"chats"`
+}
+
+// ChatInfo describes a chat as seen by the requesting user.
+type ChatInfo struct {
+	Name      string `json:"name"`
+	Group     bool   `json:"group,omitempty"`
+	Protected bool   `json:"protected,omitempty"`
+	Joined    bool   `json:"joined,omitempty"`
+	// role of the user, empty if the chat has no owner or the user is
+	// not a member
+	Role string `json:"role,omitempty"`
+	// seq of the last message, messages after the last read receipt of
+	// the user and when the last message was published
+	Seq          uint64    `json:"seq,omitempty"`
+	Unread       uint64    `json:"unread,omitempty"`
+	LastActivity time.Time `json:"last_activity,omitempty"`
+}
+
+// Goodbye is the last frame of a side closing the connection on purpose.
+// The peer closes without an error and does not expect a reconnect.
+type Goodbye struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+type Ping struct {
+	Time time.Time `json:"time"`
+}
+
+// Pong answers a ping and echoes its Time, so the pinging side can measure
+// the round trip without keeping state.
+type Pong struct {
+	Time time.Time `json:"time"`
+}
+
+// Receipt reports that User got (delivered) or saw (read) the messages of
+// Chat up to Seq. Clients send it without User, the server fills it in when
+// relaying the receipt to the other members.
+type Receipt struct {
+	Chat  string `json:"chat"`
+	Seq   uint64 `json:"seq"`
+	State string `json:"state"`
+	User  string `json:"user,omitempty"`
+}
+
+const (
+	ReceiptDelivered = "delivered"
+	ReceiptRead      = "read"
+)
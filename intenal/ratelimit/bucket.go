@@ -0,0 +1,70 @@
+// Package ratelimit implements the token buckets limiting how fast messages
+// are sent, used by the client and andrewd alike.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Limit is a token bucket setting: Rate tokens per second refill a bucket
+// of Burst tokens. The zero Limit does not limit.
+type Limit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+func (l Limit) Unlimited() bool {
+	return l.Rate <= 0 || l.Burst <= 0
+}
+
+// Bucket takes one token per message. It is safe for concurrent use.
+type Bucket struct {
+	mu     sync.Mutex
+	limit  Limit
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket returns a full bucket.
+func NewBucket(l Limit) *Bucket {
+	return &Bucket{
+		limit:  l,
+		tokens: float64(l.Burst),
+		last:   time.Now(),
+	}
+}
+
+// Allow takes a token if there is one. Otherwise it reports how long until
+// the next token is available.
+func (b *Bucket) Allow() (bool, time.Duration) {
+	if b.limit.Unlimited() {
+		return true, 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.limit.Rate
+	b.tokens = min(b.tokens, float64(b.limit.Burst))
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := (1 - b.tokens) / b.limit.Rate
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+// Refund gives back a token taken by Allow, for a message dropped after all.
+func (b *Bucket) Refund() {
+	if b.limit.Unlimited() {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.tokens+1, float64(b.limit.Burst))
+}
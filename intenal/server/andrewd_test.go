@@ -0,0 +1,313 @@
+package server
+
+import (
+	"andrew_chat/intenal/andrewd"
+	"andrew_chat/intenal/config"
+	"andrew_chat/intenal/domain"
+	"andrew_chat/intenal/domain/chat"
+	"andrew_chat/intenal/protocol"
+	"andrew_chat/intenal/ratelimit"
+	"context"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// the client keeps its outbox next to the config
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "andrew_chat")
+	if err != nil {
+		panic(err)
+	}
+	path := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(path, []byte(`{"servers":[]}`), 0644); err != nil {
+		panic(err)
+	}
+	config.InitConfig(path)
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// startDaemon serves cfg on a local port and returns a server entry
+// pointing at it.
+func startDaemon(t *testing.T, cfg andrewd.Config) domain.Server {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := andrewd.New(cfg)
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+
+	return domain.Server{
+		Name:     "andrewd",
+		Address:  "127.0.0.1",
+		Port:     l.Addr().(*net.TCPAddr).Port,
+		Protocol: "tcp",
+		Proxy:    proxyDirect,
+	}
+}
+
+// hash makes a password hash in the format of andrewd.HashPassword, with
+// few iterations to keep the tests fast.
+func hash(t *testing.T, password string) string {
+	t.Helper()
+	salt := []byte("0123456789abcdef")
+	key, err := pbkdf2.Key(sha256.New, password, salt, 1000, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := base64.RawStdEncoding
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", 1000, enc.EncodeToString(salt), enc.EncodeToString(key))
+}
+
+// client is one user connected to the daemon.
+type client struct {
+	ss *ServerService
+	id string
+}
+
+// connect connects to srv as user and waits until it is connected. The
+// password prompts are answered with the passwords, in turn.
+func connect(t *testing.T, srv domain.Server, user string, passwords ...string) *client {
+	t.Helper()
+	srv.ID = uuid.NewString()
+	srv.Username = user
+	ss := NewServerService()
+	ss.Connect(context.Background(), srv)
+	t.Cleanup(ss.DisconnectAll)
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev := <-ss.Events():
+			switch {
+			case ev.Password != nil:
+				if len(passwords) == 0 {
+					t.Fatalf("%s: unexpected prompt %q", user, ev.Password.Text)
+				}
+				ev.Password.Answer(passwords[0])
+				passwords = passwords[1:]
+			case ev.Status == StatusConnected:
+				return &client{ss: ss, id: srv.ID}
+			case ev.Err != nil:
+				t.Fatalf("%s: connect: %v", user, ev.Err)
+			}
+		case <-timeout:
+			t.Fatalf("%s: not connected", user)
+		}
+	}
+}
+
+func (c *client) join(t *testing.T, chat, password string) {
+	t.Helper()
+	if err := c.ss.Join(context.Background(), c.id, chat, password); err != nil {
+		t.Fatalf("join %s: %v", chat, err)
+	}
+}
+
+func (c *client) send(t *testing.T, chat, text string) string {
+	t.Helper()
+	msg, err := c.ss.Send(c.id, chat, text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg.ID
+}
+
+// next returns the next chat event matching ok, skipping the others.
+func (c *client) next(t *testing.T, ok func(ChatEvent) bool) ChatEvent {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev := <-c.ss.ChatEvents():
+			if ok(ev) {
+				return ev
+			}
+		case <-timeout:
+			t.Fatal("no chat event")
+		}
+	}
+}
+
+// state waits for the next delivery state change of message id.
+func (c *client) state(t *testing.T, id string) ChatEvent {
+	t.Helper()
+	return c.next(t, func(ev ChatEvent) bool { return ev.Message == nil && ev.ID == id })
+}
+
+// message waits for the next message arriving in a joined chat.
+func (c *client) message(t *testing.T) chat.Message {
+	t.Helper()
+	return *c.next(t, func(ev ChatEvent) bool { return ev.Message != nil }).Message
+}
+
+func errorCode(err error) string {
+	var e protocol.Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return ""
+}
+
+func TestDaemonSignIn(t *testing.T) {
+	srv := startDaemon(t, andrewd.Config{
+		Users: []andrewd.UserConfig{{Name: "al", PasswordHash: hash(t, "al-secret")}},
+	})
+
+	// asked once more after the wrong one
+	connect(t, srv, "al", "guess", "al-secret")
+	// guests are not asked
+	connect(t, srv, "eve")
+}
+
+func TestDaemonCompression(t *testing.T) {
+	srv := startDaemon(t, andrewd.Config{})
+
+	for _, tc := range []struct{ setting, want string }{
+		{"", protocol.Compressions[0]},
+		{"gzip", "gzip"},
+		{"flate", "flate"},
+		{compressionOff, ""},
+	} {
+		srv.Compression = tc.setting
+		c := connect(t, srv, "al")
+		stats, ok := c.ss.Compression(c.id)
+		if !ok {
+			t.Fatalf("%q: not connected", tc.setting)
+		}
+		if stats.Algo != tc.want {
+			t.Errorf("%q: compression %q, want %q", tc.setting, stats.Algo, tc.want)
+		}
+	}
+}
+
+func TestDaemonJoin(t *testing.T) {
+	srv := startDaemon(t, andrewd.Config{Rooms: []andrewd.RoomConfig{
+		{Name: "general", Group: true},
+		{Name: "vault", Group: true, PasswordHash: hash(t, "open sesame")},
+	}})
+	c := connect(t, srv, "al")
+
+	c.join(t, "general", "")
+	err := c.ss.Join(context.Background(), c.id, "vault", "")
+	if code := errorCode(err); code != protocol.CodeUnauthorized {
+		t.Errorf("join without the password: %v, want %s", err, protocol.CodeUnauthorized)
+	}
+	err = c.ss.Join(context.Background(), c.id, "vault", "guess")
+	if code := errorCode(err); code != protocol.CodeUnauthorized {
+		t.Errorf("join with a wrong password: %v, want %s", err, protocol.CodeUnauthorized)
+	}
+	c.join(t, "vault", "open sesame")
+}
+
+func TestDaemonDelivery(t *testing.T) {
+	srv := startDaemon(t, andrewd.Config{Rooms: []andrewd.RoomConfig{{Name: "general", Group: true}}})
+	al := connect(t, srv, "al")
+	bo := connect(t, srv, "bo")
+	al.join(t, "general", "")
+	bo.join(t, "general", "")
+
+	id := al.send(t, "general", "hi")
+	if ev := al.state(t, id); ev.State != chat.Sent || ev.Err != nil {
+		t.Fatalf("state %v %v, want sent", ev.State, ev.Err)
+	}
+	first := bo.message(t)
+	if first.ID != id || first.Author != "al" || first.Text != "hi" {
+		t.Fatalf("bo got %+v", first)
+	}
+
+	// a resend after a lost ack is acked again, but not delivered twice
+	c := al.ss.get(al.id)
+	if err := c.outbox.add(protocol.Message{ID: id, Chat: "general", Text: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	c.pump()
+	// the receipts of bo may come first
+	al.next(t, func(ev ChatEvent) bool { return ev.ID == id && ev.State == chat.Sent })
+	if len(c.outbox.pending()) != 0 {
+		t.Error("resent message not acked")
+	}
+
+	next := al.send(t, "general", "again")
+	if msg := bo.message(t); msg.ID != next {
+		t.Errorf("bo got %q (seq %d) after %q, want %q", msg.Text, msg.Seq, first.Text, "again")
+	}
+}
+
+func TestDaemonRateLimited(t *testing.T) {
+	srv := startDaemon(t, andrewd.Config{
+		Rooms:     []andrewd.RoomConfig{{Name: "general", Group: true}},
+		UserLimit: ratelimit.Limit{Rate: 5, Burst: 1},
+	})
+	al := connect(t, srv, "al")
+	al.join(t, "general", "")
+
+	al.send(t, "general", "one")
+	id := al.send(t, "general", "two")
+	ev := al.state(t, id)
+	var e protocol.Error
+	if !errors.As(ev.Err, &e) || e.Code != protocol.CodeRateLimited {
+		t.Fatalf("second message: %v, want %s", ev.Err, protocol.CodeRateLimited)
+	}
+	if ev.State != chat.Pending {
+		t.Errorf("throttled message %v, want pending", ev.State)
+	}
+
+	// resent once the server allows it, as the heartbeat does; the wait is
+	// cut to whole milliseconds
+	time.Sleep(time.Duration(e.RetryAfterMs+1) * time.Millisecond)
+	al.ss.get(al.id).pump()
+	if ev := al.state(t, id); ev.State != chat.Sent || ev.Err != nil {
+		t.Errorf("after the wait: %v %v, want sent", ev.State, ev.Err)
+	}
+}
+
+func TestDaemonMembers(t *testing.T) {
+	srv := startDaemon(t, andrewd.Config{
+		Users: []andrewd.UserConfig{
+			{Name: "al", PasswordHash: hash(t, "al-secret")},
+			{Name: "bo", PasswordHash: hash(t, "bo-secret")},
+		},
+		Rooms: []andrewd.RoomConfig{{Name: "team", Group: true, Owner: "al"}},
+	})
+	al := connect(t, srv, "al", "al-secret")
+	bo := connect(t, srv, "bo", "bo-secret")
+	ctx := context.Background()
+
+	err := bo.ss.Join(ctx, bo.id, "team", "")
+	if code := errorCode(err); code != protocol.CodeForbidden {
+		t.Fatalf("join before the invite: %v, want %s", err, protocol.CodeForbidden)
+	}
+	if err := al.ss.Member(ctx, al.id, "team", "bo", protocol.MemberInvite); err != nil {
+		t.Fatalf("invite: %v", err)
+	}
+	bo.join(t, "team", "")
+
+	err = bo.ss.Member(ctx, bo.id, "team", "al", protocol.MemberKick)
+	if code := errorCode(err); code != protocol.CodeForbidden {
+		t.Errorf("member kicks the owner: %v, want %s", err, protocol.CodeForbidden)
+	}
+
+	if err := al.ss.Member(ctx, al.id, "team", "bo", protocol.MemberKick); err != nil {
+		t.Fatalf("kick: %v", err)
+	}
+	ev := bo.next(t, func(ev ChatEvent) bool { return ev.Message == nil && ev.Err != nil })
+	if ev.Chat != "team" || !strings.Contains(ev.Err.Error(), "removed") {
+		t.Errorf("kicked: %+v", ev)
+	}
+}
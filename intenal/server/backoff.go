@@ -0,0 +1,39 @@
+package server
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	backoffBase = 500 * time.Millisecond
+	backoffMax  = 30 * time.Second
+)
+
+// backoff produces capped exponential delays with equal jitter: the delay of
+// attempt n is uniformly distributed in [d/2, d), d = min(max, base*2^(n-1)).
+type backoff struct {
+	base    time.Duration
+	max     time.Duration
+	attempt int
+}
+
+func newBackoff() *backoff {
+	return &backoff{base: backoffBase, max: backoffMax}
+}
+
+func (b *backoff) next() time.Duration {
+	b.attempt++
+
+	d := b.max
+	if shift := b.attempt - 1; shift < 32 && b.base<<shift < b.max {
+		d = b.base << shift
+	}
+
+	half := d / 2
+	return half + rand.N(d-half)
+}
+
+func (b *backoff) reset() {
+	b.attempt = 0
+}
@@ -0,0 +1,476 @@
+package server
+
+import (
+	"andrew_chat/intenal/debug"
+	"andrew_chat/intenal/domain"
+	"andrew_chat/intenal/domain/chat"
+	"andrew_chat/intenal/protocol"
+	"andrew_chat/intenal/ratelimit"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	dialTimeout  = 5 * time.Second
+	writeTimeout = 10 * time.Second
+)
+
+var errNotConnected = errors.New("not connected")
+
+// connection keeps one server connected. It is owned by ServerService.
+type connection struct {
+	mu         sync.Mutex
+	server     domain.Server
+	conn       net.Conn
+	enc        *protocol.Encoder
+	dec        *protocol.Decoder
+	done       chan struct{}
+	cancel     context.CancelFunc
+	lastUpdate time.Time
+	// negotiated with the server by the last handshake
+	caps     protocol.Caps
+	compress string
+
+	// serializes frames written by different goroutines
+	wmu sync.Mutex
+
+	// heartbeat state of the current connection
+	missed  int
+	latency time.Duration
+	// reason to report when the current connection is dropped
+	dropErr error
+
+	// requests waiting for their answer, by ID
+	requests map[string]chan protocol.Frame
+
+	// entered for srv.Username last, tried first on every connect
+	signInPassword string
+
+	// joined chats and their passwords
+	chats  map[string]string
+	outbox *outbox
+	limit  *ratelimit.Bucket
+	// orders outbox writes; sentAt tracks when the current connection
+	// last wrote each queued message
+	omu    sync.Mutex
+	sentAt map[string]time.Time
+	// throttled messages, the ones after them wait until they are acked
+	held map[string]bool
+	// acked messages waiting for receipts, by chat
+	tracked map[string][]tracked
+
+	// last emitted event
+	state  Event
+	events chan<- Event
+	chatEv chan<- ChatEvent
+	// bumped by connect, so that a replaced run stays silent
+	gen int
+	// a probe only checks the server, it never pins its key
+	probe bool
+}
+
+func newConnection(srv domain.Server, events chan<- Event, chatEv chan<- ChatEvent) *connection {
+	return &connection{
+		server:   srv,
+		state:    Event{Server: srv, Status: StatusDisconnected},
+		events:   events,
+		chatEv:   chatEv,
+		requests: make(map[string]chan protocol.Frame),
+		chats:    make(map[string]string),
+		outbox:   openOutbox(srv.ID),
+		limit:    newSendBucket(),
+		sentAt:   make(map[string]time.Time),
+		held:     make(map[string]bool),
+		tracked:  make(map[string][]tracked),
+	}
+}
+
+func (c *connection) emit(gen int, ev Event) {
+	c.mu.Lock()
+	stale := gen != c.gen
+	if !stale {
+		c.state = ev
+	}
+	c.mu.Unlock()
+
+	if !stale {
+		c.events <- ev
+	}
+}
+
+func (c *connection) snapshot() Event {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// connect replaces the current connection with one to srv. It returns
+// immediately, the connection is kept up in the background: whenever a dial
+// fails or the connection drops, it is retried with capped exponential
+// backoff until ctx is cancelled, terminate or cancelReconnect is called.
+func (c *connection) connect(ctx context.Context, srv domain.Server) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	c.mu.Lock()
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.closeLocked()
+	c.cancel = cancel
+	c.server = srv
+	c.gen++
+	gen := c.gen
+	c.state = Event{Server: srv, Status: StatusConnecting}
+	c.mu.Unlock()
+
+	go c.run(ctx, srv, gen)
+}
+
+func (c *connection) run(ctx context.Context, srv domain.Server, gen int) {
+	b := newBackoff()
+	c.emit(gen, Event{Server: srv, Status: StatusConnecting})
+
+	for {
+		// dial may pin a key, always use the latest server settings
+		c.mu.Lock()
+		srv = c.server
+		c.mu.Unlock()
+
+		err := c.dial(ctx, gen, srv)
+		if err == nil {
+			b.reset()
+			c.emit(gen, Event{Server: srv, Status: StatusConnected})
+			c.resume()
+			err = c.serve(gen)
+		}
+
+		if ctx.Err() != nil {
+			c.emit(gen, Event{Server: srv, Status: StatusDisconnected})
+			return
+		}
+
+		// retrying cannot help until the user decides about the new key
+		var pinErr *PinMismatchError
+		if errors.As(err, &pinErr) {
+			c.stop(gen)
+			c.emit(gen, Event{Server: srv, Status: StatusDisconnected, Err: err})
+			return
+		}
+
+		delay := b.next()
+		c.emit(gen, Event{
+			Server:  srv,
+			Status:  StatusConnecting,
+			Err:     err,
+			Attempt: b.attempt,
+			RetryAt: time.Now().Add(delay),
+		})
+
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			c.emit(gen, Event{Server: srv, Status: StatusDisconnected, Err: err})
+			return
+		}
+	}
+}
+
+// stop releases the context of run gen, unless it was replaced already.
+func (c *connection) stop(gen int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gen == gen && c.cancel != nil {
+		c.cancel()
+		c.cancel = nil
+	}
+}
+
+// dial connects and runs the handshake. On success the connection becomes
+// the current one unless ctx was cancelled meanwhile.
+func (c *connection) dial(ctx context.Context, gen int, srv domain.Server) error {
+	conn, err := c.dialTransport(ctx, gen, srv)
+	if err != nil {
+		return err
+	}
+
+	enc := protocol.NewEncoder(conn)
+	dec := protocol.NewDecoder(conn)
+	// Disconnect does not wait for the handshake timeout
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	hello, err := handshake(conn, enc, dec, srv, c.password(ctx, gen, srv))
+	stop()
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		conn.Close()
+		return err
+	}
+
+	c.conn = conn
+	c.enc = enc
+	c.dec = dec
+	c.caps = hello.Caps
+	c.compress = ""
+	if len(hello.Compress) > 0 {
+		c.compress = hello.Compress[0]
+	}
+	c.done = make(chan struct{})
+	c.lastUpdate = time.Now()
+	c.missed = 0
+	c.latency = 0
+	c.dropErr = nil
+
+	go c.heartbeat(conn, c.done)
+	return nil
+}
+
+// password signs in with the password entered last, none at first, and
+// asks the user for another one when the server refuses it.
+func (c *connection) password(ctx context.Context, gen int, srv domain.Server) passwordFunc {
+	return func(refused error) (string, error) {
+		if refused == nil {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return c.signInPassword, nil
+		}
+
+		text := fmt.Sprintf("Password for %s on %s", srv.Username, srv.Address)
+		c.mu.Lock()
+		if c.signInPassword != "" {
+			text = "Wrong password. " + text
+		}
+		c.mu.Unlock()
+
+		req := newPasswordRequest(text)
+		c.emit(gen, Event{Server: srv, Status: StatusConnecting, Password: req})
+		pw, err := req.wait(ctx)
+		if err != nil {
+			return "", err
+		}
+		c.mu.Lock()
+		c.signInPassword = pw
+		c.mu.Unlock()
+		return pw, nil
+	}
+}
+
+// dialTransport opens the stream the chat protocol runs on.
+func (c *connection) dialTransport(ctx context.Context, gen int, srv domain.Server) (net.Conn, error) {
+	switch srv.Protocol {
+	case "ssh":
+		if _, ok := srv.UnixSocket(); ok {
+			return nil, errors.New("ssh: unix socket address is not supported")
+		}
+		return c.dialSSH(ctx, gen, srv)
+	case "http", "https":
+		return c.dialWebSocket(ctx, srv)
+	}
+	return c.dialStream(ctx, srv)
+}
+
+// dialStream connects to srv over TCP or a unix domain socket, wrapped in
+// TLS for TLS protocols.
+func (c *connection) dialStream(ctx context.Context, srv domain.Server) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	if path, ok := srv.UnixSocket(); ok {
+		d := net.Dialer{Timeout: dialTimeout}
+		conn, err = d.DialContext(ctx, "unix", path)
+	} else {
+		conn, err = dialTCP(ctx, srv, address(srv))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !srv.UsesTLS() {
+		return conn, nil
+	}
+
+	tconn, firstUse, err := wrapTLS(ctx, conn, srv)
+	if err != nil {
+		return nil, err
+	}
+	if !firstUse {
+		return tconn, nil
+	}
+	if err := c.pinFirstUse(tconn); err != nil {
+		tconn.Close()
+		return nil, fmt.Errorf("pin certificate: %w", err)
+	}
+	return tconn, nil
+}
+
+// serve reads frames until the connection fails, then drops it.
+func (c *connection) serve(gen int) error {
+	c.mu.Lock()
+	conn, dec, srv := c.conn, c.dec, c.server
+	c.mu.Unlock()
+
+	var err error
+	for {
+		var f protocol.Frame
+		f, err = dec.Decode()
+		if err != nil {
+			break
+		}
+		debug.DebugDump(debug.VV, "frame", f.Type, string(f.Payload))
+
+		c.mu.Lock()
+		c.lastUpdate = time.Now()
+		c.mu.Unlock()
+
+		switch f.Type {
+		case protocol.TypePing:
+			var p protocol.Ping
+			if f.Decode(&p) == nil {
+				c.write(protocol.TypePong, protocol.Pong{Time: p.Time})
+			}
+		case protocol.TypeAck:
+			var p protocol.Ack
+			if f.Decode(&p) == nil && !c.answer(p.ID, f) {
+				c.acked(p)
+			}
+		case protocol.TypeError:
+			var p protocol.Error
+			if f.Decode(&p) == nil && p.ID != "" && !c.answer(p.ID, f) {
+				c.rejected(p)
+			}
+		case protocol.TypeChats:
+			var p protocol.Chats
+			if f.Decode(&p) == nil {
+				c.answer(p.ID, f)
+			}
+		case protocol.TypeMessage:
+			var p protocol.Message
+			if f.Decode(&p) != nil {
+				break
+			}
+			if p.Author != srv.Username && c.has(protocol.CapReceipts) {
+				c.write(protocol.TypeReceipt, protocol.Receipt{
+					Chat:  p.Chat,
+					Seq:   p.Seq,
+					State: protocol.ReceiptDelivered,
+				})
+			}
+			msg := messageOf(p)
+			c.notify(ChatEvent{Chat: p.Chat, Message: &msg, ID: p.ID, State: chat.Sent})
+		case protocol.TypeLeave:
+			var p protocol.Leave
+			if f.Decode(&p) == nil {
+				c.removed(p.Chat)
+			}
+		case protocol.TypeReceipt:
+			var p protocol.Receipt
+			if f.Decode(&p) == nil {
+				c.receipt(p)
+			}
+		case protocol.TypePong:
+			var p protocol.Pong
+			if f.Decode(&p) == nil {
+				rtt := c.pong(p)
+				c.emit(gen, Event{Server: srv, Status: StatusConnected, Latency: rtt})
+			}
+		}
+	}
+
+	c.mu.Lock()
+	if c.dropErr != nil {
+		err = c.dropErr
+	}
+	if c.conn == conn {
+		c.closeLocked()
+	}
+	c.mu.Unlock()
+	return fmt.Errorf("connection lost: %w", err)
+}
+
+// write sends one frame on the current connection.
+func (c *connection) write(t protocol.Type, payload any) error {
+	c.mu.Lock()
+	conn, enc := c.conn, c.enc
+	c.mu.Unlock()
+
+	if conn == nil {
+		return errNotConnected
+	}
+
+	f, err := protocol.NewFrame(t, payload)
+	if err != nil {
+		return err
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return enc.Encode(f)
+}
+
+// has reports whether the server supports capability name.
+func (c *connection) has(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.caps.Has(name)
+}
+
+// reconnecting reports whether the connection is waiting to come up.
+func (c *connection) reconnecting() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cancel != nil && c.conn == nil
+}
+
+// cancelReconnect stops retrying. It has no effect on an established
+// connection.
+func (c *connection) cancelReconnect() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil && c.cancel != nil {
+		c.cancel()
+		c.cancel = nil
+	}
+}
+
+// setPin replaces the pinned key used by the next dial.
+func (c *connection) setPin(fp string) domain.Server {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.server.PinnedSPKI = fp
+	return c.server
+}
+
+func (c *connection) terminate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cancel != nil {
+		c.cancel()
+		c.cancel = nil
+	}
+	c.closeLocked()
+}
+
+func (c *connection) closeLocked() {
+	if c.conn == nil {
+		return
+	}
+	close(c.done)
+	c.conn.Close()
+	c.conn = nil
+}
+
+func address(srv domain.Server) string {
+	return net.JoinHostPort(srv.Address, strconv.Itoa(srv.Port))
+}
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"andrew_chat/intenal/debug"
+	"andrew_chat/intenal/domain/chat"
+	"andrew_chat/intenal/protocol"
+	"fmt"
+	"slices"
+	"time"
+)
+
+const (
+	// a message not acked within ackTimeout is written again; the server
+	// drops duplicates by ID
+	ackTimeout = 10 * time.Second
+	// acked messages waiting for receipts per chat, the oldest are dropped:
+	// members that never read would keep them forever
+	maxTracked = 256
+)
+
+// ChatEvent reports activity in a chat: a message arrived, or the delivery
+// state of an outgoing message changed.
+type ChatEvent struct {
+	Server string
+	Chat   string
+	// the message that arrived, nil for delivery state changes
+	Message *chat.Message
+	// ID of the message
+	ID    string
+	State chat.DeliveryState
+	// set if the server rejected the message, then it failed, or throttled
+	// it: then it stays pending and is resent later. Also set if the outbox
+	// could not be saved after an ack.
+	Err error
+}
+
+// tracked is an acked message of this client waiting for receipts.
+type tracked struct {
+	id    string
+	seq   uint64
+	state chat.DeliveryState
+}
+
+// messageOf converts a message of the wire into the domain model.
+func messageOf(p protocol.Message) chat.Message {
+	return chat.Message{
+		ID:         p.ID,
+		ChatID:     p.Chat,
+		Author:     p.Author,
+		Text:       p.Text,
+		Seq:        p.Seq,
+		ServerTime: p.Time,
+		ClientTime: p.Sent,
+		ReplyTo:    p.ReplyTo,
+		System:     p.System,
+	}
+}
+
+func (c *connection) notify(ev ChatEvent) {
+	c.mu.Lock()
+	ev.Server = c.server.ID
+	c.mu.Unlock()
+	c.chatEv <- ev
+}
+
+// acked moves an acked message from the outbox to the receipt tracking.
+func (c *connection) acked(p protocol.Ack) {
+	msg, ok, err := c.outbox.remove(p.ID)
+	if !ok {
+		// acks of rejoins and leaves, or of a resent message acked twice
+		return
+	}
+
+	receipts := c.has(protocol.CapReceipts)
+
+	c.omu.Lock()
+	delete(c.sentAt, msg.ID)
+	held := c.held[msg.ID]
+	delete(c.held, msg.ID)
+	if receipts {
+		c.track(msg.Chat, tracked{
+			id:    msg.ID,
+			seq:   p.Seq,
+			state: chat.Sent,
+		})
+	}
+	c.omu.Unlock()
+	if held {
+		// the messages held back behind it may go
+		c.pump()
+	}
+
+	c.notify(ChatEvent{Chat: msg.Chat, ID: msg.ID, State: chat.Sent, Err: outboxErr(err)})
+}
+
+// track adds t to the messages of chatName waiting for receipts.
+//
+// caller holds c.omu
+func (c *connection) track(chatName string, t tracked) {
+	msgs := append(c.tracked[chatName], t)
+	if len(msgs) > maxTracked {
+		msgs = slices.Clone(msgs[len(msgs)-maxTracked:])
+	}
+	c.tracked[chatName] = msgs
+}
+
+// untrack forgets the messages of a chat left.
+func (c *connection) untrack(chatName string) {
+	c.omu.Lock()
+	delete(c.tracked, chatName)
+	c.omu.Unlock()
+}
+
+// rejected drops a message the server refused: it would be refused again
+// on every resend. A rate limited message stays queued and is resent once
+// the server allows it.
+func (c *connection) rejected(p protocol.Error) {
+	if p.Code == protocol.CodeRateLimited {
+		c.throttled(p)
+		return
+	}
+
+	msg, ok, err := c.outbox.remove(p.ID)
+	if !ok {
+		return
+	}
+	if err != nil {
+		// the failure of the message is reported already
+		debug.DebugDump(debug.V, "outbox", outboxErr(err))
+	}
+
+	c.omu.Lock()
+	delete(c.sentAt, msg.ID)
+	held := c.held[msg.ID]
+	delete(c.held, msg.ID)
+	c.omu.Unlock()
+	if held {
+		c.pump()
+	}
+
+	c.notify(ChatEvent{Chat: msg.Chat, ID: msg.ID, State: chat.Failed, Err: p})
+}
+
+// outboxErr explains a failed rewrite of the outbox, nil for none.
+func outboxErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("outbox not saved, sent messages may go out again after a restart: %w", err)
+}
+
+func (c *connection) throttled(p protocol.Error) {
+	var msg protocol.Message
+	for _, m := range c.outbox.pending() {
+		if m.ID == p.ID {
+			msg = m
+		}
+	}
+	if msg.ID == "" {
+		return
+	}
+
+	// pump resends ackTimeout after sentAt and holds back the messages
+	// after it meanwhile
+	wait := time.Duration(p.RetryAfterMs) * time.Millisecond
+	c.omu.Lock()
+	c.sentAt[msg.ID] = time.Now().Add(wait - ackTimeout)
+	c.held[msg.ID] = true
+	c.omu.Unlock()
+
+	c.notify(ChatEvent{Chat: msg.Chat, ID: msg.ID, State: chat.Pending, Err: p})
+}
+
+// receipt advances the tracked messages of the chat covered by p. Read
+// messages cannot change anymore and are no longer tracked.
+func (c *connection) receipt(p protocol.Receipt) {
+	state := chat.Delivered
+	if p.State == protocol.ReceiptRead {
+		state = chat.Read
+	}
+
+	var evs []ChatEvent
+	c.omu.Lock()
+	msgs := c.tracked[p.Chat][:0]
+	for _, t := range c.tracked[p.Chat] {
+		if t.seq <= p.Seq && t.state < state {
+			t.state = state
+			evs = append(evs, ChatEvent{Chat: p.Chat, ID: t.id, State: state})
+		}
+		if t.state != chat.Read {
+			msgs = append(msgs, t)
+		}
+	}
+	c.tracked[p.Chat] = msgs
+	c.omu.Unlock()
+
+	for _, ev := range evs {
+		c.notify(ev)
+	}
+}
+
+// markRead tells the other members that the messages of chat up to seq were
+// seen.
+func (c *connection) markRead(chat string, seq uint64) error {
+	if !c.has(protocol.CapReceipts) {
+		return nil
+	}
+	return c.write(protocol.TypeReceipt, protocol.Receipt{
+		Chat:  chat,
+		Seq:   seq,
+		State: protocol.ReceiptRead,
+	})
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"andrew_chat/intenal/protocol"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newTestConnection is a connection that is not connected, with an outbox
+// in memory. Its chat events are buffered, nobody reads them.
+func newTestConnection(caps protocol.Caps) *connection {
+	return &connection{
+		caps:    caps,
+		chats:   make(map[string]string),
+		outbox:  &outbox{},
+		sentAt:  make(map[string]time.Time),
+		held:    make(map[string]bool),
+		tracked: make(map[string][]tracked),
+		chatEv:  make(chan ChatEvent, 2*maxTracked),
+	}
+}
+
+// ack queues n messages in chat and acks them.
+func ack(t *testing.T, c *connection, chat string, n int) {
+	t.Helper()
+	for i := 1; i <= n; i++ {
+		id := chat + strconv.Itoa(i)
+		if err := c.outbox.add(protocol.Message{ID: id, Chat: chat}); err != nil {
+			t.Fatal(err)
+		}
+		c.acked(protocol.Ack{ID: id, Seq: uint64(i)})
+	}
+}
+
+func TestTrackedWithoutReceipts(t *testing.T) {
+	c := newTestConnection(nil)
+	ack(t, c, "general", 3)
+	if n := len(c.tracked["general"]); n != 0 {
+		t.Errorf("%d messages tracked, the server sends no receipts", n)
+	}
+}
+
+func TestTrackedBounded(t *testing.T) {
+	c := newTestConnection(protocol.Caps{protocol.CapReceipts})
+	ack(t, c, "general", maxTracked+10)
+
+	msgs := c.tracked["general"]
+	if len(msgs) != maxTracked {
+		t.Fatalf("%d messages tracked, want %d", len(msgs), maxTracked)
+	}
+	if msgs[0].seq != 11 {
+		t.Errorf("oldest tracked seq %d, want 11", msgs[0].seq)
+	}
+}
+
+func TestTrackedDroppedOnLeave(t *testing.T) {
+	c := newTestConnection(protocol.Caps{protocol.CapReceipts})
+	ack(t, c, "general", 3)
+	ack(t, c, "team", 2)
+
+	// not connected, the chat is forgotten anyway
+	c.leave("general")
+	if _, ok := c.tracked["general"]; ok {
+		t.Error("left chat still tracked")
+	}
+	if n := len(c.tracked["team"]); n != 2 {
+		t.Errorf("%d messages of team tracked, want 2", n)
+	}
+}
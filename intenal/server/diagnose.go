@@ -0,0 +1,259 @@
+package server
+
+import (
+	"andrew_chat/intenal/domain"
+	"andrew_chat/intenal/protocol"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// bounds a whole diagnosis, a password prompt included
+const diagnoseTimeout = time.Minute
+
+// diagnosis reports the steps of Diagnose.
+type diagnosis struct {
+	ctx context.Context
+	out chan<- string
+}
+
+func (d diagnosis) printf(format string, args ...any) {
+	select {
+	case d.out <- fmt.Sprintf(format, args...):
+	case <-d.ctx.Done():
+	}
+}
+
+func (d diagnosis) step(name string) {
+	d.printf("")
+	d.printf("== %s", name)
+}
+
+// Diagnose walks the way to srv step by step: name resolution, TCP connect,
+// TLS handshake and the protocol hello and auth. Each result is sent to out
+// as a line of text as soon as it is known; out is closed when done, or
+// soon after ctx is cancelled whether out is read or not. Unlike Connect it
+// never pins a key or otherwise changes srv.
+func (ss *ServerService) Diagnose(ctx context.Context, srv domain.Server, out chan<- string) {
+	defer close(out)
+
+	ctx, cancel := context.WithTimeout(ctx, diagnoseTimeout)
+	defer cancel()
+	d := diagnosis{ctx: ctx, out: out}
+
+	d.printf("Diagnosing %s (%s)", srv.Name, srv.Description())
+
+	addr, ok := d.resolve(srv)
+	if !ok {
+		return
+	}
+	if !d.connect(srv, addr) {
+		return
+	}
+	if !d.protocol(srv) {
+		return
+	}
+	d.compression(ss, srv)
+}
+
+// resolve returns the address the transport dials.
+func (d diagnosis) resolve(srv domain.Server) (string, bool) {
+	d.step("resolve")
+
+	if path, ok := srv.UnixSocket(); ok {
+		fi, err := os.Stat(path)
+		if err != nil {
+			d.printf("FAIL %v", err)
+			return "", false
+		}
+		if fi.Mode()&os.ModeSocket == 0 {
+			d.printf("FAIL %s is not a socket", path)
+			return "", false
+		}
+		d.printf("ok   %s is a socket", path)
+		return path, true
+	}
+
+	port := srv.Port
+	if srv.Protocol == "ssh" && port == 0 {
+		port = defaultSSHPort
+	}
+	addr := net.JoinHostPort(srv.Address, strconv.Itoa(port))
+
+	if u, err := proxyFor(srv, addr); err != nil {
+		d.printf("FAIL %v", err)
+		return "", false
+	} else if u != nil {
+		d.printf("via proxy %s://%s, the proxy resolves %s", u.Scheme, u.Host, srv.Address)
+		return addr, true
+	}
+
+	if ip := net.ParseIP(srv.Address); ip != nil {
+		d.printf("ok   %s is an IP address", srv.Address)
+		return addr, true
+	}
+
+	start := time.Now()
+	ips, err := net.DefaultResolver.LookupHost(d.ctx, srv.Address)
+	if err != nil {
+		d.printf("FAIL %v", err)
+		return "", false
+	}
+	d.printf("ok   %s -> %s in %s", srv.Address, strings.Join(ips, ", "),
+		time.Since(start).Round(time.Millisecond))
+	return addr, true
+}
+
+func (d diagnosis) connect(srv domain.Server, addr string) bool {
+	d.step("connect")
+
+	start := time.Now()
+	var conn net.Conn
+	var err error
+	if _, ok := srv.UnixSocket(); ok {
+		dl := net.Dialer{Timeout: dialTimeout}
+		conn, err = dl.DialContext(d.ctx, "unix", addr)
+	} else {
+		conn, err = dialTCP(d.ctx, srv, addr)
+	}
+	if err != nil {
+		d.printf("FAIL %v", err)
+		return false
+	}
+	d.printf("ok   %s -> %s in %s", conn.LocalAddr(), addr,
+		time.Since(start).Round(time.Millisecond))
+
+	if !srv.UsesTLS() {
+		conn.Close()
+		return true
+	}
+	return d.tls(srv, conn)
+}
+
+func (d diagnosis) tls(srv domain.Server, conn net.Conn) bool {
+	d.step("tls")
+
+	start := time.Now()
+	tconn, firstUse, err := wrapTLS(d.ctx, conn, srv)
+	if err != nil {
+		d.printf("FAIL %v", err)
+		return false
+	}
+	defer tconn.Close()
+
+	cs := tconn.ConnectionState()
+	leaf := cs.PeerCertificates[0]
+	d.printf("ok   handshake in %s", time.Since(start).Round(time.Millisecond))
+	d.printf("     version  %s, cipher %s", tls.VersionName(cs.Version),
+		tls.CipherSuiteName(cs.CipherSuite))
+	d.printf("     name     %s", cs.ServerName)
+	d.printf("     subject  %s", leaf.Subject)
+	d.printf("     issuer   %s", leaf.Issuer)
+	d.printf("     valid    %s to %s", leaf.NotBefore.Format(time.DateOnly),
+		leaf.NotAfter.Format(time.DateOnly))
+	if time.Now().After(leaf.NotAfter) {
+		d.printf("WARN certificate expired")
+	}
+
+	fp := spkiFingerprint(leaf)
+	d.printf("     key      %s", fp)
+	switch {
+	case firstUse:
+		d.printf("     chain not verified, connect pins this key")
+	case srv.PinnedSPKI != "":
+		d.printf("     matches the pin")
+	}
+	switch {
+	case srv.InsecureSkipVerify:
+		d.printf("WARN chain and name not verified")
+	case firstUse, srv.TrustOnFirstUse && srv.PinnedSPKI != "":
+		// the pin vouches for the key
+	case srv.CAFile != "":
+		d.printf("     chain verified against %s", srv.CAFile)
+	default:
+		d.printf("     chain verified against the system roots")
+	}
+	return true
+}
+
+// protocol runs the hello and auth over the full transport of srv.
+func (d diagnosis) protocol(srv domain.Server) bool {
+	d.step("protocol")
+
+	events := make(chan Event, 1)
+	probe := &connection{server: srv, events: events, probe: true}
+	go func() {
+		for ev := range events {
+			// nobody answers prompts of a diagnosis
+			if ev.Password != nil {
+				d.printf("     %s: skipped, connect to enter it", ev.Password.Text)
+				ev.Password.Cancel()
+			}
+		}
+	}()
+	defer close(events)
+
+	start := time.Now()
+	conn, err := probe.dialTransport(d.ctx, 0, srv)
+	if err != nil {
+		d.printf("FAIL %v", err)
+		return false
+	}
+	defer conn.Close()
+	// the hello does not wait for a closed window
+	stop := context.AfterFunc(d.ctx, func() { conn.Close() })
+	defer stop()
+	d.printf("ok   %s transport up in %s", transportName(srv),
+		time.Since(start).Round(time.Millisecond))
+
+	start = time.Now()
+	hello, err := handshake(conn, protocol.NewEncoder(conn), protocol.NewDecoder(conn), srv, nil)
+	if err != nil {
+		d.printf("FAIL %v", err)
+		return false
+	}
+	d.printf("ok   hello and auth as %q in %s", srv.Username,
+		time.Since(start).Round(time.Millisecond))
+	d.printf("     server   %s, protocol version %d", hello.Agent, hello.Version)
+	if len(hello.Caps) == 0 {
+		d.printf("     caps     none")
+	} else {
+		d.printf("     caps     %s", strings.Join(hello.Caps, ", "))
+	}
+	return true
+}
+
+func transportName(srv domain.Server) string {
+	switch srv.Protocol {
+	case "ssh":
+		return "ssh tunnel"
+	case "http", "https":
+		return "websocket"
+	}
+	if srv.UsesTLS() {
+		return "tls"
+	}
+	return "tcp"
+}
+
+// compression reports the compression of the live connection, if any.
+func (d diagnosis) compression(ss *ServerService, srv domain.Server) {
+	d.step("compression")
+
+	st, ok := ss.Compression(srv.ID)
+	switch {
+	case !ok:
+		d.printf("     not connected")
+	case st.Algo == "":
+		d.printf("     connection is not compressed")
+	default:
+		d.printf("     %s", st.Algo)
+		d.printf("     sent     %d -> %d bytes, ratio %.2f", st.Sent.Raw, st.Sent.Wire, st.Sent.Ratio())
+		d.printf("     received %d -> %d bytes, ratio %.2f", st.Received.Raw, st.Received.Wire, st.Received.Ratio())
+	}
+}
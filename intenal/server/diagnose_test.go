@@ -0,0 +1,88 @@
+package server
+
+import (
+	"andrew_chat/intenal/andrewd"
+	"andrew_chat/intenal/domain"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// closed waits for Diagnose to close out, dropping the lines left.
+func closed(t *testing.T, out <-chan string) {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-out:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("diagnosis still running after the cancel")
+		}
+	}
+}
+
+func TestDiagnoseCancelledUnread(t *testing.T) {
+	srv := startDaemon(t, andrewd.Config{})
+	srv.ID = "diag"
+	srv.Username = "al"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	out := make(chan string)
+	go NewServerService().Diagnose(ctx, srv, out)
+
+	// the window closes after the first line, nobody reads the others
+	<-out
+	cancel()
+	closed(t, out)
+}
+
+func TestDiagnoseCancelledHello(t *testing.T) {
+	// a server that accepts, then never answers the hello
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		var conns []net.Conn
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				break
+			}
+			conns = append(conns, conn)
+		}
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	srv := domain.Server{
+		Address:  "127.0.0.1",
+		Port:     l.Addr().(*net.TCPAddr).Port,
+		Protocol: "tcp",
+		Username: "al",
+		Proxy:    proxyDirect,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out := make(chan string, 64)
+	go NewServerService().Diagnose(ctx, srv, out)
+
+	for line := range out {
+		if strings.HasPrefix(line, "ok   tcp transport up") {
+			// waiting for the hello now
+			cancel()
+			break
+		}
+	}
+	if ctx.Err() == nil {
+		t.Fatal("diagnosis ended before the hello")
+	}
+	closed(t, out)
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"andrew_chat/intenal/protocol"
+	"time"
+)
+
+const (
+	// how long a disconnect waits for queued messages to be acked
+	drainTimeout = 3 * time.Second
+	drainPoll    = 50 * time.Millisecond
+)
+
+// disconnect closes the connection on purpose: reconnecting stops, queued
+// messages get up to drainTimeout to be written and acked, throttled ones
+// are resent meanwhile once the server allows. Then the server gets a
+// goodbye frame. Messages still queued stay in the outbox for the
+// next connect. The run loop reports StatusDisconnected once the connection
+// is closed.
+func (c *connection) disconnect() {
+	c.mu.Lock()
+	if c.cancel != nil {
+		c.cancel()
+		c.cancel = nil
+	}
+	connected := c.conn != nil
+	c.mu.Unlock()
+
+	if !connected {
+		return
+	}
+
+	c.pump()
+	deadline := time.Now().Add(drainTimeout)
+	for len(c.outbox.pending()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(drainPoll)
+		c.pump()
+	}
+
+	c.write(protocol.TypeGoodbye, protocol.Goodbye{Reason: "disconnect"})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closeLocked()
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"andrew_chat/intenal/andrewd"
+	"andrew_chat/intenal/domain/chat"
+	"andrew_chat/intenal/protocol"
+	"andrew_chat/intenal/ratelimit"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDisconnectDrains(t *testing.T) {
+	srv := startDaemon(t, andrewd.Config{
+		Rooms:     []andrewd.RoomConfig{{Name: "general", Group: true}},
+		UserLimit: ratelimit.Limit{Rate: 4, Burst: 1},
+	})
+	al := connect(t, srv, "al")
+	al.join(t, "general", "")
+
+	al.send(t, "general", "one")
+	id := al.send(t, "general", "two")
+	ev := al.state(t, id)
+	var e protocol.Error
+	if !errors.As(ev.Err, &e) || e.Code != protocol.CodeRateLimited {
+		t.Fatalf("second message: %v, want %s", ev.Err, protocol.CodeRateLimited)
+	}
+	if retry := time.Duration(e.RetryAfterMs) * time.Millisecond; retry >= drainTimeout {
+		t.Fatalf("retry after %s, longer than the drain", retry)
+	}
+
+	// the throttled message is resent while draining
+	al.ss.Disconnect(al.id)
+	if msgs := al.ss.get(al.id).outbox.pending(); len(msgs) != 0 {
+		t.Fatalf("%d messages left after the drain", len(msgs))
+	}
+	if ev := al.state(t, id); ev.State != chat.Sent {
+		t.Errorf("throttled message %v, want sent", ev.State)
+	}
+}
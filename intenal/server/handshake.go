@@ -0,0 +1,149 @@
+package server
+
+import (
+	"andrew_chat/intenal/domain"
+	"andrew_chat/intenal/protocol"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	handshakeTimeout = 10 * time.Second
+	agent            = "andrew_chat"
+)
+
+// capabilities implemented by the client
+var clientCaps = protocol.Caps{protocol.CapReceipts, protocol.CapCompression}
+
+const compressionOff = "off"
+
+// compressions returns the algorithms to offer to srv.
+func compressions(srv domain.Server) []string {
+	switch srv.Compression {
+	case "":
+		return protocol.Compressions
+	case compressionOff:
+		return nil
+	}
+	return []string{srv.Compression}
+}
+
+// passwordFunc returns the password to sign in with: the one known for a
+// nil refused, otherwise a new one after the server refused the last.
+type passwordFunc func(refused error) (string, error)
+
+// handshake exchanges hello frames and authenticates as srv.Username.
+// It returns the hello of the remote side, its Caps narrowed to the ones
+// both sides support. Without password it signs in as a guest only.
+func handshake(conn net.Conn, enc *protocol.Encoder, dec *protocol.Decoder,
+	srv domain.Server, password passwordFunc) (protocol.Hello, error) {
+
+	var hello protocol.Hello
+
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	offered := compressions(srv)
+	err := send(enc, protocol.TypeHello, protocol.Hello{
+		Version:  protocol.Version,
+		Agent:    agent,
+		Caps:     clientCaps,
+		Compress: offered,
+	})
+	if err != nil {
+		return hello, err
+	}
+	if err := expect(dec, protocol.TypeHello, &hello); err != nil {
+		return hello, err
+	}
+	if err := enc.SetVersion(hello.Version); err != nil {
+		return hello, err
+	}
+	// a server is not trusted to stay within what was offered
+	hello.Caps = clientCaps.Intersect(hello.Caps)
+
+	if err := authenticate(conn, enc, dec, srv, password); err != nil {
+		return hello, fmt.Errorf("auth: %w", err)
+	}
+
+	if !hello.Caps.Has(protocol.CapCompression) || len(hello.Compress) == 0 {
+		hello.Compress = nil
+		return hello, nil
+	}
+	algo := hello.Compress[0]
+	if !protocol.Caps(offered).Has(algo) {
+		return hello, fmt.Errorf("server picked compression %q, not offered", algo)
+	}
+	if err := enc.Compress(algo); err != nil {
+		return hello, err
+	}
+	return hello, dec.Decompress(algo)
+}
+
+// authenticate signs in, asking for another password as long as the server
+// refuses it. The handshake deadline is lifted while the user is asked.
+func authenticate(conn net.Conn, enc *protocol.Encoder, dec *protocol.Decoder,
+	srv domain.Server, password passwordFunc) error {
+
+	var refused error
+	for {
+		auth := protocol.Auth{
+			ID:       uuid.NewString(),
+			Username: srv.Username,
+		}
+		if password != nil {
+			if refused != nil {
+				conn.SetDeadline(time.Time{})
+			}
+			pw, err := password(refused)
+			conn.SetDeadline(time.Now().Add(handshakeTimeout))
+			if err != nil {
+				return err
+			}
+			auth.Password = pw
+		}
+		if err := send(enc, protocol.TypeAuth, auth); err != nil {
+			return err
+		}
+
+		var ack protocol.Ack
+		err := expect(dec, protocol.TypeAck, &ack)
+		var e protocol.Error
+		if password == nil || !errors.As(err, &e) || e.Code != protocol.CodeUnauthorized {
+			return err
+		}
+		refused = e
+	}
+}
+
+func send(enc *protocol.Encoder, t protocol.Type, payload any) error {
+	f, err := protocol.NewFrame(t, payload)
+	if err != nil {
+		return err
+	}
+	return enc.Encode(f)
+}
+
+// expect reads the next frame into v. An error frame is returned as
+// protocol.Error.
+func expect(dec *protocol.Decoder, t protocol.Type, v any) error {
+	f, err := dec.Decode()
+	if err != nil {
+		return err
+	}
+	if f.Type == protocol.TypeError {
+		var e protocol.Error
+		if err := f.Decode(&e); err != nil {
+			return err
+		}
+		return e
+	}
+	if f.Type != t {
+		return fmt.Errorf("unexpected %s frame, want %s", f.Type, t)
+	}
+	return f.Decode(v)
+}
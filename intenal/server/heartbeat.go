@@ -0,0 +1,62 @@
+package server
+
+import (
+	"andrew_chat/intenal/protocol"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	heartbeatInterval = 5 * time.Second
+	// pings without a pong before the peer is considered dead
+	heartbeatMaxMissed = 3
+)
+
+var errHeartbeatTimeout = fmt.Errorf("heartbeat timeout: %d pings missed",
+	heartbeatMaxMissed)
+
+// heartbeat pings the peer of conn until done is closed. A half-open
+// connection never fails a read, so after heartbeatMaxMissed unanswered
+// pings conn is closed here, which makes serve drop it.
+func (c *connection) heartbeat(conn net.Conn, done <-chan struct{}) {
+	t := time.NewTicker(heartbeatInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+
+		c.mu.Lock()
+		if c.conn != conn {
+			c.mu.Unlock()
+			return
+		}
+		if c.missed >= heartbeatMaxMissed {
+			c.dropErr = errHeartbeatTimeout
+			conn.Close()
+			c.mu.Unlock()
+			return
+		}
+		c.missed++
+		c.mu.Unlock()
+
+		c.write(protocol.TypePing, protocol.Ping{Time: time.Now()})
+		// resend messages whose ack timed out
+		c.pump()
+	}
+}
+
+// pong records the round trip of an answered ping.
+func (c *connection) pong(p protocol.Pong) time.Duration {
+	rtt := time.Since(p.Time)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.missed = 0
+	c.latency = rtt
+	return rtt
+}
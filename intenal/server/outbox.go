@@ -0,0 +1,160 @@
+package server
+
+import (
+	"andrew_chat/intenal/config"
+	"andrew_chat/intenal/debug"
+	"andrew_chat/intenal/protocol"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// outbox holds outgoing messages of one server until the server acks them.
+// It is a JSON lines file, appended on send and rewritten on ack, so queued
+// messages survive restarts and go out in order once connected.
+type outbox struct {
+	mu   sync.Mutex
+	path string
+	msgs []protocol.Message
+}
+
+// openOutbox loads the outbox of the server. On failure the outbox still
+// works, in memory only.
+func openOutbox(serverID string) *outbox {
+	o := &outbox{
+		path: filepath.Join(config.Dir(), "outbox", serverID+".jsonl"),
+	}
+	if err := o.load(); err != nil {
+		debug.DebugDump(debug.V, "outbox "+o.path+" kept in memory", err)
+		o.path = ""
+	}
+	return o
+}
+
+func (o *outbox) load() error {
+	if err := os.MkdirAll(filepath.Dir(o.path), 0700); err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(o.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, protocol.MaxPayload)
+	for sc.Scan() {
+		var msg protocol.Message
+		// a line torn by a crash during append is dropped
+		if err := json.Unmarshal(sc.Bytes(), &msg); err != nil {
+			continue
+		}
+		o.msgs = append(o.msgs, msg)
+	}
+	return sc.Err()
+}
+
+// add queues msg once it is stored. A message that could not be stored is
+// not queued, the caller may send it again.
+func (o *outbox) add(msg protocol.Message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if err := o.appendLocked(msg); err != nil {
+		return err
+	}
+	o.msgs = append(o.msgs, msg)
+	return nil
+}
+
+func (o *outbox) appendLocked(msg protocol.Message) error {
+	if o.path == "" {
+		return nil
+	}
+
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(o.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		// a torn line would spoil the next one appended
+		f.Truncate(fi.Size())
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Truncate(fi.Size())
+		return err
+	}
+	return nil
+}
+
+// remove drops the message with id and returns it, false if it was not
+// queued. The error reports a failed rewrite of the file: the message is
+// gone from memory, but comes back after a restart.
+func (o *outbox) remove(id string) (protocol.Message, bool, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	idx := -1
+	for i := range o.msgs {
+		if o.msgs[i].ID == id {
+			idx = i
+			break
+		}
+	}
+	if idx == -1 {
+		return protocol.Message{}, false, nil
+	}
+	msg := o.msgs[idx]
+	o.msgs = append(o.msgs[:idx], o.msgs[idx+1:]...)
+
+	return msg, true, o.rewriteLocked()
+}
+
+// rewriteLocked replaces the file atomically with the queued messages.
+func (o *outbox) rewriteLocked() error {
+	if o.path == "" {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, msg := range o.msgs {
+		if err := enc.Encode(msg); err != nil {
+			return err
+		}
+	}
+
+	tmp := o.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, o.path)
+}
+
+// pending returns the queued messages, oldest first.
+func (o *outbox) pending() []protocol.Message {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	msgs := make([]protocol.Message, len(o.msgs))
+	copy(msgs, o.msgs)
+	return msgs
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"andrew_chat/intenal/protocol"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOutboxAddFailed(t *testing.T) {
+	// the directory of the file is missing, nothing can be stored
+	o := &outbox{path: filepath.Join(t.TempDir(), "missing", "srv.jsonl")}
+
+	if err := o.add(protocol.Message{ID: "1", Chat: "general", Text: "hi"}); err == nil {
+		t.Fatal("add without a file succeeded")
+	}
+	if n := len(o.pending()); n != 0 {
+		t.Errorf("%d messages queued, want none: a retry would send a copy", n)
+	}
+}
+
+func TestOutboxRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "srv.jsonl")
+	o := &outbox{path: path}
+	for _, id := range []string{"1", "2", "3"} {
+		if err := o.add(protocol.Message{ID: id, Chat: "general"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok, err := o.remove("2"); !ok || err != nil {
+		t.Fatalf("remove: %v %v", ok, err)
+	}
+
+	reopened := &outbox{path: path}
+	if err := reopened.load(); err != nil {
+		t.Fatal(err)
+	}
+	msgs := reopened.pending()
+	if len(msgs) != 2 || msgs[0].ID != "1" || msgs[1].ID != "3" {
+		t.Errorf("after restart: %+v, want 1 and 3", msgs)
+	}
+}
+
+func TestOutboxRemoveFailed(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "outbox")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	o := &outbox{path: filepath.Join(dir, "srv.jsonl")}
+	if err := o.add(protocol.Message{ID: "1", Chat: "general"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the rewrite cannot replace the file
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	msg, ok, err := o.remove("1")
+	if !ok || msg.ID != "1" {
+		t.Fatalf("remove: %+v %v", msg, ok)
+	}
+	if err == nil {
+		t.Error("failed rewrite not reported")
+	}
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"andrew_chat/intenal/config"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+)
+
+// PinMismatchError is returned when a server presents a key other than the
+// one pinned on first use. The connection is not retried until the user
+// accepts Got with AcceptPin or removes the pin.
+type PinMismatchError struct {
+	Pinned string
+	Got    string
+}
+
+func (e *PinMismatchError) Error() string {
+	return fmt.Sprintf("certificate key changed: pinned %s, got %s", e.Pinned, e.Got)
+}
+
+// spkiFingerprint formats the SHA-256 of the certificate's public key info
+// the way HPKP did: "sha256/<base64>".
+func spkiFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return "sha256/" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// pinFirstUse pins the key of conn, a server with TrustOnFirstUse whose
+// chain did not verify.
+func (c *connection) pinFirstUse(conn *tls.Conn) error {
+	certs := conn.ConnectionState().PeerCertificates
+	fp := spkiFingerprint(certs[0])
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.server.PinnedSPKI != "" || c.probe {
+		return nil
+	}
+	if err := config.PinServer(c.server.ID, fp); err != nil {
+		return err
+	}
+	c.server.PinnedSPKI = fp
+	return nil
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"andrew_chat/intenal/config"
+	"andrew_chat/intenal/domain"
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPollInterval    = 30 * time.Second
+	defaultPollConcurrency = 4
+)
+
+// Probe is the result of the last reachability check of a saved server.
+type Probe struct {
+	ServerID string
+	Up       bool
+	// TCP connect time, zero if down
+	Latency time.Duration
+	// why the server is down
+	Err error
+	At  time.Time
+}
+
+// ProbeEvents delivers the result of every probe made by Poll.
+func (ss *ServerService) ProbeEvents() <-chan Probe {
+	return ss.probeEv
+}
+
+// Probe returns the last probe of the server, false if not probed yet.
+func (ss *ServerService) Probe(serverID string) (Probe, bool) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	p, ok := ss.probes[serverID]
+	return p, ok
+}
+
+// Poll probes every saved server right away and then once per interval of
+// the config, until ctx is cancelled. A probe only opens and closes a TCP
+// connection, so it needs no credentials and leaves the server settings
+// alone.
+func (ss *ServerService) Poll(ctx context.Context) {
+	for {
+		ss.pollOnce(ctx)
+
+		seconds, _ := config.GetPoll()
+		interval := defaultPollInterval
+		if seconds > 0 {
+			interval = time.Duration(seconds) * time.Second
+		}
+
+		t := time.NewTimer(interval)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return
+		}
+	}
+}
+
+// pollOnce probes all saved servers, at most as many at once as the config
+// allows.
+func (ss *ServerService) pollOnce(ctx context.Context) {
+	_, n := config.GetPoll()
+	if n <= 0 {
+		n = defaultPollConcurrency
+	}
+	sem := make(chan struct{}, n)
+
+	var wg sync.WaitGroup
+	for _, srv := range config.GetServers() {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			p := probe(ctx, srv)
+			ss.mu.Lock()
+			ss.probes[srv.ID] = p
+			ss.mu.Unlock()
+
+			select {
+			case ss.probeEv <- p:
+			case <-ctx.Done():
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// probe times a connect to the address the transport of srv dials first.
+func probe(ctx context.Context, srv domain.Server) Probe {
+	p := Probe{ServerID: srv.ID, At: time.Now()}
+
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+
+	var conn net.Conn
+	var err error
+	start := time.Now()
+	if path, ok := srv.UnixSocket(); ok {
+		d := net.Dialer{}
+		conn, err = d.DialContext(ctx, "unix", path)
+	} else {
+		port := srv.Port
+		if srv.Protocol == "ssh" && port == 0 {
+			port = defaultSSHPort
+		}
+		conn, err = dialTCP(ctx, srv, net.JoinHostPort(srv.Address, strconv.Itoa(port)))
+	}
+	if err != nil {
+		p.Err = err
+		return p
+	}
+	p.Latency = time.Since(start)
+	p.Up = true
+	conn.Close()
+	return p
+}
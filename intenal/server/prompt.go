@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// how long a dial waits for the user to answer a prompt
+const promptTimeout = 2 * time.Minute
+
+var (
+	errPromptCancelled = errors.New("password prompt cancelled")
+	errPromptTimeout   = errors.New("password prompt timed out")
+)
+
+// PasswordRequest asks the user for a secret a dial in progress needs.
+// It is delivered in Event.Password and must be answered or cancelled.
+type PasswordRequest struct {
+	Text string
+
+	answer chan string
+	cancel chan struct{}
+	once   sync.Once
+}
+
+func newPasswordRequest(text string) *PasswordRequest {
+	return &PasswordRequest{
+		Text:   text,
+		answer: make(chan string, 1),
+		cancel: make(chan struct{}),
+	}
+}
+
+func (r *PasswordRequest) Answer(secret string) {
+	r.once.Do(func() {
+		r.answer <- secret
+	})
+}
+
+func (r *PasswordRequest) Cancel() {
+	r.once.Do(func() {
+		close(r.cancel)
+	})
+}
+
+func (r *PasswordRequest) wait(ctx context.Context) (string, error) {
+	t := time.NewTimer(promptTimeout)
+	defer t.Stop()
+
+	select {
+	case secret := <-r.answer:
+		return secret, nil
+	case <-r.cancel:
+		return "", errPromptCancelled
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case <-t.C:
+		return "", errPromptTimeout
+	}
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"andrew_chat/intenal/config"
+	"andrew_chat/intenal/domain"
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// domain.Server.Proxy value that bypasses the global and environment proxy
+const proxyDirect = "direct"
+
+// HopError tells which hop of a proxied connection failed: reaching the
+// proxy itself or the proxy reaching the target.
+type HopError struct {
+	Hop string
+	Err error
+}
+
+func (e *HopError) Error() string {
+	return e.Hop + ": " + e.Err.Error()
+}
+
+func (e *HopError) Unwrap() error {
+	return e.Err
+}
+
+func getenv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// proxyFor returns the proxy to reach addr of srv through, nil for a
+// direct connection. The server setting wins over the global one, which
+// wins over ALL_PROXY. NO_PROXY exempts addr from the latter two.
+func proxyFor(srv domain.Server, addr string) (*url.URL, error) {
+	raw := srv.Proxy
+	if raw == proxyDirect {
+		return nil, nil
+	}
+	if raw == "" {
+		if bypassProxy(addr) {
+			return nil, nil
+		}
+		raw = config.GetProxy()
+	}
+	if raw == "" {
+		raw = getenv("ALL_PROXY", "all_proxy")
+	}
+	if raw == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("proxy %q: %w", raw, err)
+	}
+	switch u.Scheme {
+	case "socks5", "socks5h", "http":
+	default:
+		return nil, fmt.Errorf("proxy %q: unsupported scheme %q", raw, u.Scheme)
+	}
+	if u.Port() == "" {
+		return nil, fmt.Errorf("proxy %q: port is missing", raw)
+	}
+	return u, nil
+}
+
+// bypassProxy matches addr against NO_PROXY: a comma separated list of
+// "*", host names matching themselves and their subdomains, IPs and CIDRs,
+// each optionally with a port.
+func bypassProxy(addr string) bool {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+
+	for _, entry := range strings.Split(getenv("NO_PROXY", "no_proxy"), ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			if p != port {
+				continue
+			}
+			entry = h
+		}
+		if _, n, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && n.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		entry = strings.TrimPrefix(strings.TrimPrefix(entry, "*"), ".")
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// dialTCP connects to addr, through the proxy of srv if there is one.
+func dialTCP(ctx context.Context, srv domain.Server, addr string) (net.Conn, error) {
+	d := &net.Dialer{Timeout: dialTimeout}
+
+	u, err := proxyFor(srv, addr)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return d.DialContext(ctx, "tcp", addr)
+	}
+
+	var conn net.Conn
+	switch u.Scheme {
+	case "http":
+		conn, err = dialConnect(ctx, d, u, addr)
+	default:
+		conn, err = dialSOCKS5(ctx, d, u, addr)
+	}
+
+	if err != nil {
+		var hopErr *HopError
+		if errors.As(err, &hopErr) {
+			return nil, hopErr
+		}
+		return nil, &HopError{Hop: fmt.Sprintf("%s via %s proxy %s", addr, u.Scheme, u.Host), Err: err}
+	}
+	return conn, nil
+}
+
+// proxyDialer reaches the proxy, failures are reported as the proxy hop.
+type proxyDialer struct {
+	d *net.Dialer
+}
+
+func (p proxyDialer) Dial(network, addr string) (net.Conn, error) {
+	return p.DialContext(context.Background(), network, addr)
+}
+
+func (p proxyDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := p.d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, &HopError{Hop: "proxy " + addr, Err: err}
+	}
+	return conn, nil
+}
+
+func dialSOCKS5(ctx context.Context, d *net.Dialer, u *url.URL, addr string) (net.Conn, error) {
+	var auth *proxy.Auth
+	if u.User != nil {
+		pass, _ := u.User.Password()
+		auth = &proxy.Auth{User: u.User.Username(), Password: pass}
+	}
+
+	dialer, err := proxy.SOCKS5("tcp", u.Host, auth, proxyDialer{d})
+	if err != nil {
+		return nil, err
+	}
+	return dialer.(proxy.ContextDialer).DialContext(ctx, "tcp", addr)
+}
+
+// dialConnect opens a tunnel with an HTTP CONNECT request.
+func dialConnect(ctx context.Context, d *net.Dialer, u *url.URL, addr string) (net.Conn, error) {
+	conn, err := proxyDialer{d}.DialContext(ctx, "tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if u.User != nil {
+		pass, _ := u.User.Password()
+		cred := base64.StdEncoding.EncodeToString([]byte(u.User.Username() + ":" + pass))
+		req.Header.Set("Proxy-Authorization", "Basic "+cred)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("CONNECT: %s", resp.Status)
+	}
+
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn returns bytes the proxy sent right after its response
+// before reading from the connection again.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
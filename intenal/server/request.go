@@ -0,0 +1,57 @@
+package server
+
+import (
+	"andrew_chat/intenal/protocol"
+	"context"
+	"time"
+)
+
+// how long a request waits for its answer
+const requestTimeout = 10 * time.Second
+
+// request sends a frame and waits for the answer carrying the same id. An
+// error frame is returned as protocol.Error.
+func (c *connection) request(ctx context.Context, t protocol.Type, id string, payload any) (protocol.Frame, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	ch := make(chan protocol.Frame, 1)
+	c.mu.Lock()
+	c.requests[id] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.requests, id)
+		c.mu.Unlock()
+	}()
+
+	if err := c.write(t, payload); err != nil {
+		return protocol.Frame{}, err
+	}
+
+	select {
+	case f := <-ch:
+		if f.Type == protocol.TypeError {
+			var e protocol.Error
+			if err := f.Decode(&e); err != nil {
+				return f, err
+			}
+			return f, e
+		}
+		return f, nil
+	case <-ctx.Done():
+		return protocol.Frame{}, ctx.Err()
+	}
+}
+
+// answer passes f to the request waiting for id, reporting whether there
+// was one.
+func (c *connection) answer(id string, f protocol.Frame) bool {
+	c.mu.Lock()
+	ch, ok := c.requests[id]
+	c.mu.Unlock()
+	if ok {
+		ch <- f
+	}
+	return ok
+}
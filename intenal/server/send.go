@@ -0,0 +1,175 @@
+package server
+
+import (
+	"andrew_chat/intenal/config"
+	"andrew_chat/intenal/domain/chat"
+	"andrew_chat/intenal/protocol"
+	"andrew_chat/intenal/ratelimit"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// messages per server unless the config sets a limit
+var defaultSendLimit = ratelimit.Limit{Rate: 1, Burst: 5}
+
+// SlowDownError is returned by Send when messages are sent faster than the
+// limit allows. The message is not queued.
+type SlowDownError struct {
+	RetryAfter time.Duration
+}
+
+func (e *SlowDownError) Error() string {
+	return fmt.Sprintf("slow down: try again in %s", e.RetryAfter.Round(100*time.Millisecond))
+}
+
+func newSendBucket() *ratelimit.Bucket {
+	limit, ok := config.GetSendLimit()
+	if !ok {
+		limit = defaultSendLimit
+	}
+	return ratelimit.NewBucket(limit)
+}
+
+// join enters chat and remembers it, so that it is joined again after a
+// reconnect. It waits for the server to accept, a wrong password is
+// returned as protocol.Error.
+func (c *connection) join(ctx context.Context, chat, password string) error {
+	id := uuid.NewString()
+	_, err := c.request(ctx, protocol.TypeJoin, id, protocol.Join{
+		ID:       id,
+		Chat:     chat,
+		Password: password,
+	})
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.chats[chat] = password
+	c.mu.Unlock()
+	return nil
+}
+
+// setPassword changes the password of chat, empty removes it.
+func (c *connection) setPassword(ctx context.Context, chat, password string) error {
+	id := uuid.NewString()
+	_, err := c.request(ctx, protocol.TypeChatPassword, id, protocol.ChatPassword{
+		ID:       id,
+		Chat:     chat,
+		Password: password,
+	})
+	if err != nil {
+		return err
+	}
+
+	// the owner joins again with the new one after a reconnect
+	c.mu.Lock()
+	if _, ok := c.chats[chat]; ok {
+		c.chats[chat] = password
+	}
+	c.mu.Unlock()
+	return nil
+}
+
+// removed forgets chat after the server removed this user from it.
+func (c *connection) removed(chatName string) {
+	c.mu.Lock()
+	delete(c.chats, chatName)
+	c.mu.Unlock()
+	c.untrack(chatName)
+
+	c.notify(ChatEvent{Chat: chatName, Err: fmt.Errorf("you were removed from %s", chatName)})
+}
+
+func (c *connection) leave(chat string) error {
+	c.mu.Lock()
+	delete(c.chats, chat)
+	c.mu.Unlock()
+	c.untrack(chat)
+
+	return c.write(protocol.TypeLeave, protocol.Leave{
+		ID:   uuid.NewString(),
+		Chat: chat,
+	})
+}
+
+// send queues a message in the outbox and sends it if connected. The ID
+// generated here is kept across resends, so the server can drop duplicates.
+func (c *connection) send(chatName, text string) (chat.Message, error) {
+	if ok, wait := c.limit.Allow(); !ok {
+		return chat.Message{}, &SlowDownError{RetryAfter: wait}
+	}
+
+	msg := protocol.Message{
+		ID:   uuid.NewString(),
+		Chat: chatName,
+		Text: text,
+		Sent: time.Now().UTC(),
+	}
+	if err := c.outbox.add(msg); err != nil {
+		return chat.Message{}, err
+	}
+	c.pump()
+	return messageOf(msg), nil
+}
+
+// resume restores a fresh connection: chats are joined again, then the
+// outbox is flushed. Frames are handled in order by the server, so the
+// messages do not need to wait for the join acks.
+func (c *connection) resume() {
+	c.omu.Lock()
+	c.sentAt = make(map[string]time.Time)
+	c.omu.Unlock()
+
+	c.mu.Lock()
+	chats := make(map[string]string, len(c.chats))
+	for chat, password := range c.chats {
+		chats[chat] = password
+	}
+	c.mu.Unlock()
+
+	// chats of messages queued before a restart are not remembered
+	for _, msg := range c.outbox.pending() {
+		if _, ok := chats[msg.Chat]; !ok {
+			chats[msg.Chat] = ""
+		}
+	}
+
+	for chat, password := range chats {
+		c.write(protocol.TypeJoin, protocol.Join{
+			ID:       uuid.NewString(),
+			Chat:     chat,
+			Password: password,
+		})
+	}
+	c.pump()
+}
+
+// pump writes the queued messages not written on this connection yet, or
+// not acked within ackTimeout, oldest first. It stops at the first failure,
+// the rest goes out after the next reconnect, and at a throttled message:
+// the ones after it wait until it is accepted, so that the server takes
+// them in order.
+func (c *connection) pump() {
+	c.omu.Lock()
+	defer c.omu.Unlock()
+
+	for _, msg := range c.outbox.pending() {
+		if at, ok := c.sentAt[msg.ID]; ok && time.Since(at) < ackTimeout {
+			if c.held[msg.ID] {
+				return
+			}
+			continue
+		}
+		if err := c.write(protocol.TypeMessage, msg); err != nil {
+			return
+		}
+		c.sentAt[msg.ID] = time.Now()
+		if c.held[msg.ID] {
+			return
+		}
+	}
+}
@@ -3,7 +3,12 @@ package server
 import (
 	"andrew_chat/intenal/config"
 	"andrew_chat/intenal/domain"
-	"net"
+	"andrew_chat/intenal/domain/chat"
+	"andrew_chat/intenal/protocol"
+	"context"
+	"errors"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -15,24 +20,348 @@ const (
 	StatusConnecting
 )
 
+const eventBufferSize = 32
+
+var errUnknownConnection = errors.New("no connection to server")
+
+// Event reports a change of the connection state of Server.
+type Event struct {
+	Server domain.Server
+	Status int
+	// why the connection failed or was lost
+	Err error
+	// reconnect attempt, 0 for the first connect
+	Attempt int
+	// when the next reconnect attempt starts, zero if not scheduled
+	RetryAt time.Time
+	// last measured round trip, zero until the first pong
+	Latency time.Duration
+	// set when the dial waits for the user to enter a password
+	Password *PasswordRequest
+}
+
+// ServerService manages saved servers and the connections to them. Any
+// number of servers can be connected at once, connections are keyed by
+// domain.Server.ID. One of them is active: the one chats are opened on.
 type ServerService struct {
-	server     domain.Server
-	conn       net.Conn
-	done       chan struct{}
-	lastUpdate time.Time
+	mu     sync.Mutex
+	conns  map[string]*connection
+	active string
+
+	events chan Event
+	chatEv chan ChatEvent
+
+	// results of Poll, by server ID
+	probes  map[string]Probe
+	probeEv chan Probe
 }
 
 func NewServerService() *ServerService {
-	return &ServerService{}
+	return &ServerService{
+		conns:  make(map[string]*connection),
+		events: make(chan Event, eventBufferSize),
+		chatEv: make(chan ChatEvent, eventBufferSize),
+
+		probes:  make(map[string]Probe),
+		probeEv: make(chan Probe, eventBufferSize),
+	}
+}
+
+// Events delivers connection state changes of all servers.
+func (ss *ServerService) Events() <-chan Event {
+	return ss.events
+}
+
+// ChatEvents delivers the messages arriving in joined chats and the delivery
+// state changes of sent messages, of all servers.
+func (ss *ServerService) ChatEvents() <-chan ChatEvent {
+	return ss.chatEv
+}
+
+func (ss *ServerService) get(serverID string) *connection {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.conns[serverID]
+}
+
+// Connect (re)connects srv in the background and makes it active.
+func (ss *ServerService) Connect(ctx context.Context, srv domain.Server) {
+	ss.mu.Lock()
+	c, ok := ss.conns[srv.ID]
+	if !ok {
+		c = newConnection(srv, ss.events, ss.chatEv)
+		ss.conns[srv.ID] = c
+	}
+	ss.active = srv.ID
+	ss.mu.Unlock()
+
+	c.connect(ctx, srv)
+}
+
+// SetActive switches the active server to a connection made by Connect.
+func (ss *ServerService) SetActive(serverID string) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if _, ok := ss.conns[serverID]; !ok {
+		return errUnknownConnection
+	}
+	ss.active = serverID
+	return nil
+}
+
+// ActiveID returns the ID of the active server, empty if none.
+func (ss *ServerService) ActiveID() string {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.active
+}
+
+// Connections returns the current state of every connection made by
+// Connect, ordered by server name.
+func (ss *ServerService) Connections() []Event {
+	ss.mu.Lock()
+	conns := make([]*connection, 0, len(ss.conns))
+	for _, c := range ss.conns {
+		conns = append(conns, c)
+	}
+	ss.mu.Unlock()
+
+	states := make([]Event, len(conns))
+	for i, c := range conns {
+		states[i] = c.snapshot()
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Server.Name < states[j].Server.Name
+	})
+	return states
+}
+
+// State returns the current state of the connection to the server, false
+// if it was never connected.
+func (ss *ServerService) State(serverID string) (Event, bool) {
+	c := ss.get(serverID)
+	if c == nil {
+		return Event{}, false
+	}
+	return c.snapshot(), true
+}
+
+// Caps returns the capabilities negotiated with the server by the last
+// handshake, nil if it never connected. Actions needing a capability missing
+// here are not offered for the server.
+func (ss *ServerService) Caps(serverID string) protocol.Caps {
+	c := ss.get(serverID)
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.caps
+}
+
+// CompressionStats describes the compression of a connection.
+type CompressionStats struct {
+	// empty if the connection is not compressed
+	Algo     string
+	Sent     protocol.Stats
+	Received protocol.Stats
+}
+
+// Compression reports the compression of the current connection to the
+// server, false if not connected.
+func (ss *ServerService) Compression(serverID string) (CompressionStats, bool) {
+	c := ss.get(serverID)
+	if c == nil {
+		return CompressionStats{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return CompressionStats{}, false
+	}
+	return CompressionStats{
+		Algo:     c.compress,
+		Sent:     c.enc.Stats(),
+		Received: c.dec.Stats(),
+	}, true
+}
+
+// ListChats asks the server for its chats.
+func (ss *ServerService) ListChats(ctx context.Context, serverID string) ([]chat.Chat, error) {
+	c := ss.get(serverID)
+	if c == nil {
+		return nil, errUnknownConnection
+	}
+
+	id := uuid.NewString()
+	f, err := c.request(ctx, protocol.TypeList, id, protocol.List{ID: id})
+	if err != nil {
+		return nil, err
+	}
+	var p protocol.Chats
+	if err := f.Decode(&p); err != nil {
+		return nil, err
+	}
+
+	chats := make([]chat.Chat, len(p.Chats))
+	for i, info := range p.Chats {
+		var flags chat.ChatFlags
+		if info.Group {
+			flags |= chat.GroupFlag
+		}
+		if info.Protected {
+			flags |= chat.ProtectedFlag
+		}
+		chats[i] = chat.Chat{
+			Name:         info.Name,
+			Flags:        flags,
+			Joined:       info.Joined,
+			Role:         chat.Role(info.Role),
+			Seq:          info.Seq,
+			Unread:       info.Unread,
+			LastActivity: info.LastActivity,
+		}
+	}
+	return chats, nil
+}
+
+// Join enters chat on the server, password is needed for protected chats.
+// Joined chats are joined again after every reconnect.
+func (ss *ServerService) Join(ctx context.Context, serverID, chat, password string) error {
+	c := ss.get(serverID)
+	if c == nil {
+		return errUnknownConnection
+	}
+	return c.join(ctx, chat, password)
+}
+
+// Member invites, kicks, promotes or demotes user in a group, action is one
+// of the protocol.Member* actions. The server checks the role of this user.
+func (ss *ServerService) Member(ctx context.Context, serverID, chatName, user, action string) error {
+	c := ss.get(serverID)
+	if c == nil {
+		return errUnknownConnection
+	}
+	id := uuid.NewString()
+	_, err := c.request(ctx, protocol.TypeMember, id, protocol.Member{
+		ID:     id,
+		Chat:   chatName,
+		User:   user,
+		Action: action,
+	})
+	return err
+}
+
+// SetChatPassword changes the password of a chat the user owns, empty
+// removes it and makes the chat open to everyone.
+func (ss *ServerService) SetChatPassword(ctx context.Context, serverID, chat, password string) error {
+	c := ss.get(serverID)
+	if c == nil {
+		return errUnknownConnection
+	}
+	return c.setPassword(ctx, chat, password)
+}
+
+// Leave leaves chat on the server, it is no longer joined after a
+// reconnect.
+func (ss *ServerService) Leave(serverID, chat string) error {
+	c := ss.get(serverID)
+	if c == nil {
+		return errUnknownConnection
+	}
+	return c.leave(chat)
 }
 
-func (ss *ServerService) Connect(srv domain.Server) error {
-	time.Sleep(1 * time.Second)
+// Send queues text for chat in the outbox of the server. It is sent right
+// away if connected, otherwise once the server is back; the returned
+// message carries the ID the server acks it by.
+func (ss *ServerService) Send(serverID, chatName, text string) (chat.Message, error) {
+	c := ss.get(serverID)
+	if c == nil {
+		return chat.Message{}, errUnknownConnection
+	}
+	return c.send(chatName, text)
+}
+
+// MarkRead sends a read receipt for the messages of chat up to seq.
+func (ss *ServerService) MarkRead(serverID, chat string, seq uint64) error {
+	c := ss.get(serverID)
+	if c == nil {
+		return errUnknownConnection
+	}
+	return c.markRead(chat, seq)
+}
+
+// Reconnecting reports whether the connection to the server is waiting
+// to come up.
+func (ss *ServerService) Reconnecting(serverID string) bool {
+	c := ss.get(serverID)
+	return c != nil && c.reconnecting()
+}
+
+// CancelReconnect stops retrying the server. It has no effect on an
+// established connection.
+func (ss *ServerService) CancelReconnect(serverID string) {
+	if c := ss.get(serverID); c != nil {
+		c.cancelReconnect()
+	}
+}
+
+// AcceptPin pins fingerprint for the server, replacing a previous pin, and
+// reconnects it.
+func (ss *ServerService) AcceptPin(ctx context.Context, serverID, fingerprint string) error {
+	if err := config.PinServer(serverID, fingerprint); err != nil {
+		return err
+	}
+	c := ss.get(serverID)
+	if c == nil {
+		return nil
+	}
+	srv := c.setPin(fingerprint)
+	ss.Connect(ctx, srv)
 	return nil
 }
 
-func (ss *ServerService) Terminate() {
-	ss.conn.Close()
+// RemovePin forgets the pinned key of the server, the next TLS connect pins
+// whatever key the server presents.
+func (ss *ServerService) RemovePin(serverID string) error {
+	if err := config.PinServer(serverID, ""); err != nil {
+		return err
+	}
+	if c := ss.get(serverID); c != nil {
+		c.setPin("")
+	}
+	return nil
+}
+
+// Disconnect closes the connection to the server gracefully, see
+// connection.disconnect. It blocks until the connection is closed.
+func (ss *ServerService) Disconnect(serverID string) {
+	if c := ss.get(serverID); c != nil {
+		c.disconnect()
+	}
+}
+
+// DisconnectAll disconnects every server at once and waits for all of
+// them.
+func (ss *ServerService) DisconnectAll() {
+	ss.mu.Lock()
+	conns := make([]*connection, 0, len(ss.conns))
+	for _, c := range ss.conns {
+		conns = append(conns, c)
+	}
+	ss.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, c := range conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.disconnect()
+		}()
+	}
+	wg.Wait()
 }
 
 func (ss *ServerService) Add(server domain.Server) error {
@@ -45,6 +374,17 @@ func (ss *ServerService) Add(server domain.Server) error {
 }
 
 func (ss *ServerService) Remove(serverID string) error {
+	ss.mu.Lock()
+	c, ok := ss.conns[serverID]
+	delete(ss.conns, serverID)
+	if ss.active == serverID {
+		ss.active = ""
+	}
+	ss.mu.Unlock()
+
+	if ok {
+		c.terminate()
+	}
 	return config.DeleteServer(serverID)
 }
 
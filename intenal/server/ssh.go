@@ -0,0 +1,255 @@
+package server
+
+import (
+	"andrew_chat/intenal/debug"
+	"andrew_chat/intenal/domain"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	sshagent "golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	defaultSSHPort = 22
+	// where the chat server listens, as seen from the SSH server
+	defaultTunnel = "127.0.0.1:4567"
+)
+
+// keys tried when the server has no key file of its own
+var defaultSSHKeys = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+
+// sshConn is a direct-tcpip channel. Closing it also closes the SSH
+// connection it was opened on.
+//
+// The channel does not support deadlines, sshConn implements them with
+// timers. Blocked calls cannot be interrupted otherwise, so a deadline that
+// passes closes the connection: the calls return os.ErrDeadlineExceeded
+// and the connection is done, unlike a socket.
+type sshConn struct {
+	net.Conn
+	client *ssh.Client
+
+	mu    sync.Mutex
+	read  *time.Timer
+	write *time.Timer
+	// set once a deadline closed the connection
+	expired atomic.Bool
+}
+
+func (c *sshConn) Close() error {
+	err := c.Conn.Close()
+	c.client.Close()
+	return err
+}
+
+func (c *sshConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if err != nil && c.expired.Load() {
+		err = os.ErrDeadlineExceeded
+	}
+	return n, err
+}
+
+func (c *sshConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if err != nil && c.expired.Load() {
+		err = os.ErrDeadlineExceeded
+	}
+	return n, err
+}
+
+func (c *sshConn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+func (c *sshConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.read = c.arm(c.read, t)
+	return nil
+}
+
+func (c *sshConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.write = c.arm(c.write, t)
+	return nil
+}
+
+// arm replaces the timer of a deadline, none for the zero time.
+func (c *sshConn) arm(old *time.Timer, t time.Time) *time.Timer {
+	if old != nil {
+		old.Stop()
+	}
+	if t.IsZero() {
+		return nil
+	}
+	return time.AfterFunc(time.Until(t), func() {
+		c.expired.Store(true)
+		c.Close()
+	})
+}
+
+// dialSSH logs into the SSH server at srv.Address:srv.Port and opens a
+// channel to srv.Tunnel, which then carries the chat protocol.
+func (c *connection) dialSSH(ctx context.Context, gen int, srv domain.Server) (net.Conn, error) {
+	if srv.Username == "" {
+		return nil, errors.New("ssh: username is empty")
+	}
+
+	hostKeys, err := knownHostsCallback(srv)
+	if err != nil {
+		return nil, err
+	}
+
+	port := srv.Port
+	if port == 0 {
+		port = defaultSSHPort
+	}
+	addr := net.JoinHostPort(srv.Address, strconv.Itoa(port))
+
+	conn, err := dialTCP(ctx, srv, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	auth, closeAgent := c.sshAuth(ctx, gen, srv, conn)
+	defer closeAgent()
+
+	// a server that stalls fails the handshake, only the password prompt
+	// lifts the deadline, see sshAuth
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	sc, chans, reqs, err := ssh.NewClientConn(conn, addr, &ssh.ClientConfig{
+		User:            srv.Username,
+		Auth:            auth,
+		HostKeyCallback: hostKeys,
+	})
+	stop()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("ssh: %w", err)
+	}
+	conn.SetDeadline(time.Time{})
+	client := ssh.NewClient(sc, chans, reqs)
+
+	tunnel := srv.Tunnel
+	if tunnel == "" {
+		tunnel = defaultTunnel
+	}
+	ch, err := client.DialContext(ctx, "tcp", tunnel)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("ssh: open tunnel to %s: %w", tunnel, err)
+	}
+	return &sshConn{Conn: ch, client: client}, nil
+}
+
+// expandHome resolves a leading "~/" of path.
+func expandHome(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return path
+}
+
+func knownHostsCallback(srv domain.Server) (ssh.HostKeyCallback, error) {
+	path := expandHome(srv.KnownHostsFile)
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(home, ".ssh", "known_hosts")
+	}
+
+	cb, err := knownhosts.New(path)
+	if err != nil {
+		return nil, fmt.Errorf("ssh: known hosts: %w", err)
+	}
+
+	return func(host string, remote net.Addr, key ssh.PublicKey) error {
+		err := cb(host, remote, key)
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) && len(keyErr.Want) == 0 {
+			return fmt.Errorf("host %s is not in %s", host, path)
+		}
+		return err
+	}, nil
+}
+
+// sshAuth returns the methods tried in order: ssh-agent, key files and a
+// password asked from the user. The handshake deadline of conn is lifted
+// while the user is asked. The returned func releases the agent.
+func (c *connection) sshAuth(ctx context.Context, gen int, srv domain.Server, conn net.Conn) ([]ssh.AuthMethod, func()) {
+	var methods []ssh.AuthMethod
+	release := func() {}
+
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if agent, err := net.Dial("unix", sock); err == nil {
+			methods = append(methods, ssh.PublicKeysCallback(sshagent.NewClient(agent).Signers))
+			release = func() { agent.Close() }
+		} else {
+			debug.DebugDump(debug.V, "ssh agent unavailable", err)
+		}
+	}
+
+	if signers := sshKeySigners(srv); len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+
+	methods = append(methods, ssh.PasswordCallback(func() (string, error) {
+		req := newPasswordRequest(fmt.Sprintf("Password for %s@%s", srv.Username, srv.Address))
+		conn.SetDeadline(time.Time{})
+		defer conn.SetDeadline(time.Now().Add(handshakeTimeout))
+
+		c.emit(gen, Event{Server: srv, Status: StatusConnecting, Password: req})
+		return req.wait(ctx)
+	}))
+
+	return methods, release
+}
+
+func sshKeySigners(srv domain.Server) []ssh.Signer {
+	paths := []string{expandHome(srv.SSHKeyFile)}
+	if srv.SSHKeyFile == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+		paths = paths[:0]
+		for _, name := range defaultSSHKeys {
+			paths = append(paths, filepath.Join(home, ".ssh", name))
+		}
+	}
+
+	var signers []ssh.Signer
+	for _, path := range paths {
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		// keys with a passphrase are left to the agent
+		signer, err := ssh.ParsePrivateKey(pem)
+		if err != nil {
+			debug.DebugDump(debug.V, "ssh key "+path+" skipped", err)
+			continue
+		}
+		signers = append(signers, signer)
+	}
+	return signers
+}
@@ -0,0 +1,404 @@
+package server
+
+import (
+	"andrew_chat/intenal/domain"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func newSigner(t *testing.T) (ssh.Signer, ed25519.PrivateKey) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer, priv
+}
+
+// echo sends back what the tunnel receives.
+func echo(ch ssh.Channel) {
+	io.Copy(ch, ch)
+	ch.Close()
+}
+
+// silent accepts the tunnel, then neither reads nor answers.
+func silent(ch ssh.Channel) {}
+
+// startSSH runs an in-process SSH server whose direct-tcpip channels are
+// served by tunnel. It returns the listen address.
+func startSSH(t *testing.T, cfg *ssh.ServerConfig, tunnel func(ssh.Channel)) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSSH(conn, cfg, tunnel)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func serveSSH(conn net.Conn, cfg *ssh.ServerConfig, tunnel func(ssh.Channel)) {
+	sc, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer sc.Close()
+	go ssh.DiscardRequests(reqs)
+
+	for nc := range chans {
+		if nc.ChannelType() != "direct-tcpip" {
+			nc.Reject(ssh.UnknownChannelType, "only direct-tcpip")
+			continue
+		}
+		ch, chReqs, err := nc.Accept()
+		if err != nil {
+			continue
+		}
+		go ssh.DiscardRequests(chReqs)
+		go tunnel(ch)
+	}
+}
+
+// sshFixture is a server with its host key, and a client whose known_hosts
+// lists that key.
+type sshFixture struct {
+	srv domain.Server
+	dir string
+}
+
+func newSSHFixture(t *testing.T, cfg *ssh.ServerConfig) *sshFixture {
+	t.Helper()
+	return newTunnelFixture(t, cfg, echo)
+}
+
+func newTunnelFixture(t *testing.T, cfg *ssh.ServerConfig, tunnel func(ssh.Channel)) *sshFixture {
+	t.Helper()
+	// only the methods set up by a test are tried
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	hostKey, _ := newSigner(t)
+	cfg.AddHostKey(hostKey)
+	addr := startSSH(t, cfg, tunnel)
+
+	host, port, _ := net.SplitHostPort(addr)
+	p, _ := strconv.Atoi(port)
+	dir := t.TempDir()
+	f := &sshFixture{
+		srv: domain.Server{
+			ID:             "ssh",
+			Address:        host,
+			Port:           p,
+			Protocol:       "ssh",
+			Username:       "al",
+			Proxy:          proxyDirect,
+			KnownHostsFile: filepath.Join(dir, "known_hosts"),
+			SSHKeyFile:     filepath.Join(dir, "missing_key"),
+		},
+		dir: dir,
+	}
+	f.knownHosts(t, hostKey.PublicKey())
+	return f
+}
+
+// knownHosts writes keys as the known keys of the server, none for an
+// empty file.
+func (f *sshFixture) knownHosts(t *testing.T, keys ...ssh.PublicKey) {
+	t.Helper()
+	addr := net.JoinHostPort(f.srv.Address, strconv.Itoa(f.srv.Port))
+	var b strings.Builder
+	for _, key := range keys {
+		b.WriteString(knownhosts.Line([]string{knownhosts.Normalize(addr)}, key) + "\n")
+	}
+	if err := os.WriteFile(f.srv.KnownHostsFile, []byte(b.String()), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (f *sshFixture) dial(t *testing.T, events chan Event) (net.Conn, error) {
+	t.Helper()
+	c := &connection{server: f.srv, events: events}
+	return c.dialSSH(context.Background(), 0, f.srv)
+}
+
+func expectEcho(t *testing.T, conn net.Conn) {
+	t.Helper()
+	defer conn.Close()
+
+	want := []byte("hello through the tunnel")
+	if _, err := conn.Write(want); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("echo = %q, want %q", got, want)
+	}
+}
+
+// keyFixture is a server accepting the key of its client, with tunnels
+// served by tunnel.
+func keyFixture(t *testing.T, tunnel func(ssh.Channel)) *sshFixture {
+	t.Helper()
+	_, clientKey := newSigner(t)
+	clientPub, _ := ssh.NewPublicKey(clientKey.Public())
+
+	f := newTunnelFixture(t, &ssh.ServerConfig{
+		PublicKeyCallback: func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if meta.User() == "al" && bytes.Equal(key.Marshal(), clientPub.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+	}, tunnel)
+
+	block, err := ssh.MarshalPrivateKey(clientKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.srv.SSHKeyFile = filepath.Join(f.dir, "id_ed25519")
+	if err := os.WriteFile(f.srv.SSHKeyFile, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestSSHKeyAuth(t *testing.T) {
+	f := keyFixture(t, echo)
+	conn, err := f.dial(t, make(chan Event, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectEcho(t, conn)
+}
+
+func passwordConfig(password string) *ssh.ServerConfig {
+	return &ssh.ServerConfig{
+		PasswordCallback: func(meta ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if meta.User() == "al" && string(pass) == password {
+				return nil, nil
+			}
+			return nil, errors.New("wrong password")
+		},
+	}
+}
+
+func TestSSHPassword(t *testing.T) {
+	f := newSSHFixture(t, passwordConfig("secret"))
+
+	events := make(chan Event, 1)
+	prompted := make(chan string, 1)
+	go func() {
+		for ev := range events {
+			if ev.Password != nil {
+				prompted <- ev.Password.Text
+				ev.Password.Answer("secret")
+			}
+		}
+	}()
+	defer close(events)
+
+	conn, err := f.dial(t, events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := <-prompted; !strings.Contains(text, "al@") {
+		t.Errorf("prompt = %q", text)
+	}
+	expectEcho(t, conn)
+}
+
+func TestSSHPasswordCancelled(t *testing.T) {
+	f := newSSHFixture(t, passwordConfig("secret"))
+
+	events := make(chan Event, 1)
+	go func() {
+		for ev := range events {
+			if ev.Password != nil {
+				ev.Password.Cancel()
+			}
+		}
+	}()
+	defer close(events)
+
+	if _, err := f.dial(t, events); err == nil {
+		t.Fatal("connected without a password")
+	}
+}
+
+func TestSSHUnknownHost(t *testing.T) {
+	f := newSSHFixture(t, passwordConfig("secret"))
+	f.knownHosts(t)
+
+	_, err := f.dial(t, make(chan Event, 1))
+	if err == nil || !strings.Contains(err.Error(), "is not in") {
+		t.Fatalf("err = %v, want unknown host", err)
+	}
+}
+
+func TestSSHHostKeyChanged(t *testing.T) {
+	f := newSSHFixture(t, passwordConfig("secret"))
+	other, _ := newSigner(t)
+	f.knownHosts(t, other.PublicKey())
+
+	_, err := f.dial(t, make(chan Event, 1))
+	var keyErr *knownhosts.KeyError
+	if !errors.As(err, &keyErr) || len(keyErr.Want) == 0 {
+		t.Fatalf("err = %v, want a host key mismatch", err)
+	}
+}
+
+func TestSSHStalledServer(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for the handshake timeout")
+	}
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	// accepts TCP, never speaks SSH
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	addr := l.Addr().(*net.TCPAddr)
+	dir := t.TempDir()
+	srv := domain.Server{
+		Address:        addr.IP.String(),
+		Port:           addr.Port,
+		Protocol:       "ssh",
+		Username:       "al",
+		Proxy:          proxyDirect,
+		KnownHostsFile: filepath.Join(dir, "known_hosts"),
+		SSHKeyFile:     filepath.Join(dir, "missing_key"),
+	}
+	os.WriteFile(srv.KnownHostsFile, nil, 0600)
+
+	done := make(chan error, 1)
+	go func() {
+		c := &connection{server: srv, events: make(chan Event, 1)}
+		_, err := c.dialSSH(context.Background(), 0, srv)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("handshake with a silent server succeeded")
+		}
+	case <-time.After(2 * handshakeTimeout):
+		t.Fatal("handshake with a silent server did not time out")
+	}
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func TestSSHTunnelDeadlines(t *testing.T) {
+	f := keyFixture(t, silent)
+
+	conn, err := f.dial(t, make(chan Event, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := conn.Read(make([]byte, 1)); !isTimeout(err) {
+		t.Errorf("read from a silent tunnel: err = %v, want a timeout", err)
+	}
+
+	// the other end does not read, the writes fill the window and block
+	conn, err = f.dial(t, make(chan Event, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(200 * time.Millisecond))
+	buf := make([]byte, 64<<10)
+	for err == nil {
+		_, err = conn.Write(buf)
+	}
+	if !isTimeout(err) {
+		t.Errorf("write to a stalled tunnel: err = %v, want a timeout", err)
+	}
+}
+
+func TestSSHSilentTunnelCancelled(t *testing.T) {
+	f := keyFixture(t, silent)
+	c := &connection{server: f.srv, events: make(chan Event, 1)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- c.dial(ctx, 0, f.srv) }()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("handshake through a silent tunnel succeeded")
+		}
+	case <-time.After(handshakeTimeout / 2):
+		t.Fatal("cancelled dial through a silent tunnel did not return")
+	}
+}
+
+func TestSSHSilentTunnel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for the handshake timeout")
+	}
+	f := keyFixture(t, silent)
+	c := &connection{server: f.srv, events: make(chan Event, 1)}
+
+	done := make(chan error, 1)
+	go func() { done <- c.dial(context.Background(), 0, f.srv) }()
+
+	select {
+	case err := <-done:
+		if !isTimeout(err) {
+			t.Fatalf("err = %v, want a timeout", err)
+		}
+	case <-time.After(2 * handshakeTimeout):
+		t.Fatal("handshake through a silent tunnel did not time out")
+	}
+}
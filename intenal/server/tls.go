@@ -0,0 +1,117 @@
+package server
+
+import (
+	"andrew_chat/intenal/domain"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+)
+
+// tlsConfig builds the client config of srv. crypto/tls verifies the chain
+// against the CA bundle of srv or the system roots, and the host name,
+// unless InsecureSkipVerify is set; a pinned key is checked on top. With
+// TrustOnFirstUse the chain is verified by verifyPeer instead, so that a
+// pinned key can stand in for a chain that does not verify. firstUse is set
+// when the server was accepted only because it has no pin yet.
+func tlsConfig(srv domain.Server, firstUse *bool) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         srv.ServerName,
+		InsecureSkipVerify: srv.InsecureSkipVerify,
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = srv.Address
+	}
+
+	if srv.CAFile != "" {
+		pem, err := os.ReadFile(srv.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read CA bundle: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in CA bundle %s", srv.CAFile)
+		}
+	}
+
+	if srv.CertFile != "" || srv.KeyFile != "" {
+		if srv.CertFile == "" || srv.KeyFile == "" {
+			return nil, errors.New("client certificate needs both cert and key file")
+		}
+		cert, err := tls.LoadX509KeyPair(srv.CertFile, srv.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	tofu := srv.TrustOnFirstUse && !srv.InsecureSkipVerify
+	if tofu {
+		cfg.InsecureSkipVerify = true
+	}
+	roots, name := cfg.RootCAs, cfg.ServerName
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("server sent no certificate")
+		}
+		// a chain that does not verify is accepted if the pin matches, or
+		// pinned by the caller if there is none yet
+		if tofu && verifyChain(cs, roots, name) != nil && srv.PinnedSPKI == "" {
+			*firstUse = true
+			return nil
+		}
+		return checkPin(cs, srv)
+	}
+	return cfg, nil
+}
+
+// verifyChain verifies the chain the server sent against roots, the system
+// roots if nil, for name.
+func verifyChain(cs tls.ConnectionState, roots *x509.CertPool, name string) error {
+	inter := x509.NewCertPool()
+	for _, c := range cs.PeerCertificates[1:] {
+		inter.AddCert(c)
+	}
+	_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+		DNSName:       name,
+		Roots:         roots,
+		Intermediates: inter,
+	})
+	return err
+}
+
+// checkPin enforces the pinned key of srv, if any.
+func checkPin(cs tls.ConnectionState, srv domain.Server) error {
+	if srv.PinnedSPKI == "" {
+		return nil
+	}
+	if got := spkiFingerprint(cs.PeerCertificates[0]); got != srv.PinnedSPKI {
+		return &PinMismatchError{Pinned: srv.PinnedSPKI, Got: got}
+	}
+	return nil
+}
+
+// wrapTLS runs the client handshake over conn. conn is closed on failure.
+// firstUse reports that the server was trusted on first use, its key is to
+// be pinned.
+func wrapTLS(ctx context.Context, conn net.Conn, srv domain.Server) (tconn *tls.Conn, firstUse bool, err error) {
+	cfg, err := tlsConfig(srv, &firstUse)
+	if err != nil {
+		conn.Close()
+		return nil, false, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer cancel()
+
+	tconn = tls.Client(conn, cfg)
+	if err := tconn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, false, fmt.Errorf("tls handshake: %w", err)
+	}
+	return tconn, firstUse, nil
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"andrew_chat/intenal/domain"
+	"andrew_chat/intenal/wsconn"
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+
+	"github.com/gorilla/websocket"
+)
+
+const defaultWSPath = "/ws"
+
+// dialWebSocket upgrades an HTTP(S) connection to srv and returns a stream
+// carried in WebSocket messages. The underlying connection, TLS included,
+// is made by dialStream so pinning applies here as well.
+func (c *connection) dialWebSocket(ctx context.Context, srv domain.Server) (net.Conn, error) {
+	u := url.URL{
+		Scheme: "ws",
+		Host:   address(srv),
+		Path:   srv.WSPath,
+	}
+	if srv.UsesTLS() {
+		u.Scheme = "wss"
+	}
+	if u.Path == "" {
+		u.Path = defaultWSPath
+	}
+	if _, ok := srv.UnixSocket(); ok {
+		// only used for the Host header, the socket is dialed by dialStream
+		u.Host = "localhost"
+	}
+
+	dial := func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return c.dialStream(ctx, srv)
+	}
+	d := websocket.Dialer{
+		NetDialContext:    dial,
+		NetDialTLSContext: dial,
+		HandshakeTimeout:  handshakeTimeout,
+	}
+
+	ws, resp, err := d.DialContext(ctx, u.String(), nil)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("websocket %s: %s", u.String(), resp.Status)
+		}
+		return nil, fmt.Errorf("websocket %s: %w", u.String(), err)
+	}
+	return wsconn.New(ws), nil
+}
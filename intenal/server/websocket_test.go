@@ -0,0 +1,83 @@
+package server
+
+import (
+	"andrew_chat/intenal/andrewd"
+	"andrew_chat/intenal/domain"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// startWebSocket serves andrewd over WebSocket at path, over TLS if tls is
+// set, and returns a server entry pointing at it.
+func startWebSocket(t *testing.T, path string, tls bool) domain.Server {
+	t.Helper()
+	s := andrewd.New(andrewd.Config{Rooms: []andrewd.RoomConfig{{Name: "general", Group: true}}})
+	mux := http.NewServeMux()
+	mux.Handle(path, s.WebSocketHandler())
+	ts := httptest.NewUnstartedServer(mux)
+	srv := domain.Server{
+		Name:     "andrewd",
+		Address:  "127.0.0.1",
+		Protocol: "http",
+		WSPath:   path,
+		Proxy:    proxyDirect,
+	}
+	if tls {
+		ts.StartTLS()
+		srv.Protocol = "https"
+		srv.CAFile = filepath.Join(t.TempDir(), "ca.pem")
+		cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+		if err := os.WriteFile(srv.CAFile, cert, 0600); err != nil {
+			t.Fatal(err)
+		}
+	} else {
+		ts.Start()
+	}
+	// the handlers return once the daemon dropped their clients
+	t.Cleanup(ts.Close)
+	t.Cleanup(func() { s.Close() })
+
+	srv.Port = ts.Listener.Addr().(*net.TCPAddr).Port
+	return srv
+}
+
+func TestWebSocket(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		path string
+		tls  bool
+	}{
+		{"ws", defaultWSPath, false},
+		{"wss", defaultWSPath, true},
+		{"path", "/chat/ws", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := startWebSocket(t, tc.path, tc.tls)
+			al := connect(t, srv, "al")
+			bo := connect(t, srv, "bo")
+			al.join(t, "general", "")
+			bo.join(t, "general", "")
+
+			id := al.send(t, "general", "over websocket")
+			if msg := bo.message(t); msg.ID != id || msg.Text != "over websocket" {
+				t.Errorf("bo got %+v", msg)
+			}
+		})
+	}
+}
+
+func TestWebSocketWrongPath(t *testing.T) {
+	srv := startWebSocket(t, "/chat/ws", false)
+	srv.WSPath = ""
+
+	c := newConnection(srv, nil, nil)
+	if conn, err := c.dialWebSocket(t.Context(), srv); err == nil {
+		conn.Close()
+		t.Fatal("dialed a path without a WebSocket endpoint")
+	}
+}
@@ -0,0 +1,256 @@
+package chat
+
+import (
+	"andrew_chat/intenal/domain/chat"
+	"andrew_chat/intenal/protocol"
+	"andrew_chat/intenal/server"
+	"andrew_chat/intenal/ui"
+	"andrew_chat/intenal/ui/keys"
+	"andrew_chat/intenal/ui/types"
+	"context"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// =============================================================================
+// Chat list
+// =============================================================================
+
+// chatsMsg carries the answer of the server to a list request.
+type chatsMsg struct {
+	chats []chat.Chat
+	err   error
+}
+
+// implements bubbletea.model
+type ChatListModel struct {
+	//chats of the server, loaded in the background
+	list *ui.List
+
+	serverID string
+	width    int
+	height   int
+	ss       *server.ServerService
+}
+
+func NewChatList(ss *server.ServerService, serverID string) *ChatListModel {
+	return &ChatListModel{
+		ss:       ss,
+		serverID: serverID,
+	}
+}
+
+func chatsToItems(chats []chat.Chat) []list.Item {
+	items := make([]list.Item, len(chats))
+	for i := range chats {
+		items[i] = chats[i]
+	}
+	return items
+}
+
+// load asks the server for its chats.
+func (m *ChatListModel) load() tea.Cmd {
+	return func() tea.Msg {
+		chats, err := m.ss.ListChats(context.Background(), m.serverID)
+		return types.WindowMsg{
+			Model: m,
+			Msg:   chatsMsg{chats: chats, err: err},
+		}
+	}
+}
+
+func (m *ChatListModel) Init() tea.Cmd {
+	m.list = ui.NewList(nil, list.NewDefaultDelegate(), m.width, m.height)
+	return m.load()
+}
+
+func (m *ChatListModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmds []tea.Cmd
+
+	switch msg := msg.(type) {
+	case chatsMsg:
+		if msg.err != nil {
+			return m, ui.NewErrCmd("list chats failed: " + msg.err.Error())
+		}
+		m.list = ui.NewList(chatsToItems(msg.chats), list.NewDefaultDelegate(), m.width, m.height)
+		return m, nil
+
+	case types.RefreshMsg:
+		return m, m.load()
+
+	case tea.KeyMsg:
+		switch {
+		case key.Matches(msg, keys.Keys.Choose):
+			selectedItem := m.list.SelectedItem()
+			if selectedItem == nil {
+				return m, nil
+			}
+			c := selectedItem.(chat.Chat)
+			return m, func() tea.Msg {
+				return types.OpenChatMsg{Server: m.serverID, Chat: c}
+			}
+		case key.Matches(msg, keys.Keys.Options):
+			selectedItem := m.list.SelectedItem()
+			if selectedItem == nil {
+				return m, nil
+			}
+			c := selectedItem.(chat.Chat)
+			opts := m.initOptions(c)
+			if len(opts) == 0 {
+				return m, ui.NewErrCmd("nothing to do in " + c.Name)
+			}
+			control := ui.NewControlPane(opts)
+			return m, ui.NewCreateCmd(types.PositionBotLeft, control, true)
+		}
+
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+		m.height = msg.Height
+	}
+
+	_, cmd := m.list.Update(msg)
+	cmds = append(cmds, cmd)
+	return m, tea.Batch(cmds...)
+}
+
+// initOptions are the actions on c the options key offers, Enter opens it.
+func (m *ChatListModel) initOptions(c chat.Chat) []ui.Option {
+	var opts []ui.Option
+
+	// unread counts move with read receipts of members only
+	if c.Joined && c.Unread > 0 && m.ss.Caps(m.serverID).Has(protocol.CapReceipts) {
+		opts = append(opts, ui.Option{
+			Name: "mark read",
+			Action: func() tea.Cmd {
+				return func() tea.Msg {
+					if err := m.ss.MarkRead(m.serverID, c.Name, c.Seq); err != nil {
+						return ui.NewRequestErrCmd("cannot mark "+c.Name+" read", err)()
+					}
+					return m.load()()
+				}
+			},
+		})
+	}
+
+	if c.Flags&chat.GroupFlag > 0 {
+		opts = append(opts, m.memberOptions(c)...)
+	}
+
+	if c.Role != chat.RoleOwner {
+		return opts
+	}
+	if c.Flags&chat.ProtectedFlag > 0 {
+		opts = append(opts,
+			ui.Option{
+				Name: "change password",
+				Action: func() tea.Cmd {
+					return ui.NewCreateCmd(types.PositionBotRight, m.passwordForm(c.Name), true)
+				},
+			},
+			ui.Option{
+				Name: "remove password",
+				Action: func() tea.Cmd {
+					return m.setPassword(c.Name, "")
+				},
+			},
+		)
+	} else {
+		opts = append(opts, ui.Option{
+			Name: "set password",
+			Action: func() tea.Cmd {
+				return ui.NewCreateCmd(types.PositionBotRight, m.passwordForm(c.Name), true)
+			},
+		})
+	}
+	return opts
+}
+
+// memberOptions are the membership actions the role of the user allows.
+func (m *ChatListModel) memberOptions(c chat.Chat) []ui.Option {
+	var actions []string
+	if c.Role.CanManage() {
+		actions = append(actions, protocol.MemberInvite, protocol.MemberKick)
+	}
+	if c.Role.CanChangeRoles() {
+		actions = append(actions, protocol.MemberPromote, protocol.MemberDemote)
+	}
+
+	opts := make([]ui.Option, len(actions))
+	for i, action := range actions {
+		opts[i] = ui.Option{
+			Name: action,
+			Action: func() tea.Cmd {
+				return ui.NewCreateCmd(types.PositionBotRight, m.memberForm(c.Name, action), true)
+			},
+		}
+	}
+	return opts
+}
+
+func (m *ChatListModel) memberForm(chatName, action string) tea.Model {
+	fields := []types.InputFieldSpec{
+		{
+			Name:        "user",
+			Title:       "User to " + action,
+			Placeholder: "username",
+		},
+	}
+	return ui.NewInputFormModel(">> ", fields, func(values []types.InputFieldValue) tea.Cmd {
+		user := strings.TrimSpace(values[0].Value)
+		if user == "" {
+			return ui.NewErrCmd("user is empty")
+		}
+		return func() tea.Msg {
+			err := m.ss.Member(context.Background(), m.serverID, chatName, user, action)
+			if err != nil {
+				return ui.NewRequestErrCmd("cannot "+action+" "+user, err)()
+			}
+			return m.load()()
+		}
+	})
+}
+
+var passwordFields = []types.InputFieldSpec{
+	{
+		Name:   "password",
+		Title:  "New Password",
+		Secret: true,
+	},
+	{
+		Name:   "confirm",
+		Title:  "Repeat Password",
+		Secret: true,
+	},
+}
+
+func (m *ChatListModel) passwordForm(chatName string) tea.Model {
+	return ui.NewInputFormModel(">> ", passwordFields, func(values []types.InputFieldValue) tea.Cmd {
+		password, confirm := values[0].Value, values[1].Value
+		if password == "" {
+			return ui.NewErrCmd("password is empty, remove it instead")
+		}
+		if password != confirm {
+			return ui.NewErrCmd("passwords do not match")
+		}
+		return m.setPassword(chatName, password)
+	})
+}
+
+// setPassword changes the password of the chat, then reloads the list.
+func (m *ChatListModel) setPassword(chatName, password string) tea.Cmd {
+	return func() tea.Msg {
+		err := m.ss.SetChatPassword(context.Background(), m.serverID, chatName, password)
+		if err != nil {
+			return ui.NewRequestErrCmd("cannot change the password of "+chatName, err)()
+		}
+		return m.load()()
+	}
+}
+
+// View
+func (m *ChatListModel) View() string {
+	return m.list.View()
+}
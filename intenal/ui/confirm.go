@@ -0,0 +1,73 @@
+package ui
+
+import (
+	"andrew_chat/intenal/ui/keys"
+	"fmt"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+type ConfirmAction func(ok bool) tea.Cmd
+
+// Confirm asks a yes/no question and closes itself once answered.
+// Esc answers no.
+//
+// implements bubbletea.model
+type Confirm struct {
+	text   string
+	action ConfirmAction
+	yes    bool
+	width  int
+}
+
+func NewConfirm(text string, action ConfirmAction) *Confirm {
+	return &Confirm{
+		text:   text,
+		action: action,
+	}
+}
+
+func (m *Confirm) Init() tea.Cmd {
+	return nil
+}
+
+func (m *Confirm) answer(ok bool) tea.Cmd {
+	return tea.Sequence(m.action(ok), NewDeleteCmd(m))
+}
+
+func (m *Confirm) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch {
+		case key.Matches(msg, keys.Keys.Left), key.Matches(msg, keys.Keys.Right):
+			m.yes = !m.yes
+		case key.Matches(msg, keys.Keys.Choose):
+			return m, m.answer(m.yes)
+		case key.Matches(msg, keys.Keys.Close):
+			return m, m.answer(false)
+		case msg.String() == "y":
+			return m, m.answer(true)
+		case msg.String() == "n":
+			return m, m.answer(false)
+		}
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+	}
+	return m, nil
+}
+
+func (m *Confirm) button(text string, focused bool) string {
+	if focused {
+		return focusedStyle.Render("[ " + text + " ]")
+	}
+	return fmt.Sprintf("[ %s ]", blurredStyle.Render(text))
+}
+
+func (m *Confirm) View() string {
+	text := lipgloss.NewStyle().Width(m.width).Render(m.text)
+	buttons := m.button("Yes", m.yes) + "  " + m.button("No", !m.yes)
+	return text + "\n\n" + buttons + "\n\n" +
+		helpStyle.Render("←/→ choose, enter confirm, esc cancel")
+}
@@ -0,0 +1,266 @@
+package ui
+
+import (
+	"andrew_chat/intenal/color"
+	"andrew_chat/intenal/domain/chat"
+	"andrew_chat/intenal/protocol"
+	"andrew_chat/intenal/server"
+	"andrew_chat/intenal/ui/keys"
+	"andrew_chat/intenal/ui/types"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// composer line and the rule above it
+const composerHeight = 2
+
+var scrollKeys = key.NewBinding(
+	key.WithKeys("up", "down", "pgup", "pgdown"),
+)
+
+var (
+	authorStyle = lipgloss.NewStyle().Foreground(color.GColorScheme.AppName.Text).Bold(true)
+	timeStyle   = lipgloss.NewStyle().Foreground(color.GColorScheme.TextBaseDark.Text)
+	markerStyle = lipgloss.NewStyle().Foreground(color.GColorScheme.TextBaseDark.Text)
+	readStyle   = lipgloss.NewStyle().Foreground(color.GColorScheme.ButtonBlurred.Text)
+	failedStyle = lipgloss.NewStyle().Foreground(color.GColorScheme.ServerStatus["disconnected"].Text)
+	systemStyle = timeStyle.Italic(true)
+)
+
+// implements bubbletea.model
+type Conversation struct {
+	history viewport.Model
+	input   textinput.Model
+	store   *chat.Store
+	// delivery state of own messages, by ID
+	states map[string]chat.DeliveryState
+	// stick to the bottom as messages arrive, off while scrolled up
+	follow bool
+
+	ss       *server.ServerService
+	serverID string
+	chat     string
+	user     string
+
+	width  int
+	height int
+}
+
+func NewConversation(ss *server.ServerService, serverID string, chatName string) *Conversation {
+	input := textinput.New()
+	input.Prompt = "> "
+	input.Placeholder = "message " + chatName
+	input.PromptStyle = focusedStyle
+	input.PlaceholderStyle = PlaceholderStyle
+	input.Focus()
+
+	var user string
+	if ev, ok := ss.State(serverID); ok {
+		user = ev.Server.Username
+	}
+
+	return &Conversation{
+		history:  viewport.New(0, 0),
+		input:    input,
+		store:    chat.NewStore(chatName),
+		states:   make(map[string]chat.DeliveryState),
+		follow:   true,
+		ss:       ss,
+		serverID: serverID,
+		chat:     chatName,
+		user:     user,
+	}
+}
+
+func (m *Conversation) Init() tea.Cmd {
+	return textinput.Blink
+}
+
+func (m *Conversation) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+		m.height = msg.Height
+		m.history.Width = msg.Width
+		m.history.Height = max(msg.Height-composerHeight, 0)
+		m.input.Width = max(msg.Width-lipgloss.Width(m.input.Prompt)-1, 0)
+		m.render()
+		return m, nil
+
+	case types.ChatMsg:
+		if msg.Server != m.serverID || msg.Chat != m.chat {
+			return m, nil
+		}
+		if msg.Message != nil {
+			return m, m.receive(*msg.Message)
+		}
+		m.setState(msg.ID, msg.State)
+		return m, nil
+
+	case tea.KeyMsg:
+		switch {
+		case msg.Type == tea.KeyEnter:
+			return m, m.send()
+		case key.Matches(msg, keys.Keys.Close):
+			return m, NewDeleteCmd(m)
+		case key.Matches(msg, scrollKeys):
+			m.history, cmd = m.history.Update(msg)
+			m.follow = m.history.AtBottom()
+			if m.follow {
+				return m, tea.Batch(cmd, m.markRead())
+			}
+			return m, cmd
+		}
+	}
+
+	m.input, cmd = m.input.Update(msg)
+	return m, cmd
+}
+
+// Close reports the closed conversation, the chat is left unless another
+// window shows it.
+func (m *Conversation) Close() tea.Cmd {
+	return func() tea.Msg {
+		return types.ChatClosedMsg{Server: m.serverID, Chat: m.chat}
+	}
+}
+
+// send queues the composed text. The message shows up pending right away,
+// the echo of the server and the acks move it on.
+func (m *Conversation) send() tea.Cmd {
+	text := strings.TrimSpace(m.input.Value())
+	if text == "" {
+		return nil
+	}
+
+	msg, err := m.ss.Send(m.serverID, m.chat, text)
+	var slow *server.SlowDownError
+	if errors.As(err, &slow) {
+		// keep the text, so it can be sent once allowed
+		return NewErrCmd(err.Error())
+	}
+	if err != nil {
+		return NewErrCmd("send failed: " + err.Error())
+	}
+
+	m.input.Reset()
+	m.follow = true
+	msg.Author = m.user
+	m.store.Add(msg)
+	m.states[msg.ID] = chat.Pending
+	m.render()
+	return nil
+}
+
+// receive merges a message of the chat into the history, an own message
+// echoed by the server completes the pending one.
+func (m *Conversation) receive(msg chat.Message) tea.Cmd {
+	if msg.Author == m.user {
+		m.states[msg.ID] = max(m.states[msg.ID], chat.Sent)
+	}
+	if m.store.Add(msg) {
+		m.render()
+	}
+	if m.follow {
+		return m.markRead()
+	}
+	return nil
+}
+
+// setState moves the marker of an own message, never backwards: receipts
+// may overtake the ack.
+func (m *Conversation) setState(id string, state chat.DeliveryState) {
+	if _, ok := m.store.Get(id); !ok || m.states[id] >= state {
+		return
+	}
+	m.states[id] = state
+	m.render()
+}
+
+// receipts reports whether the server relays receipts: without them there
+// is nothing to mark read and no marker beyond sent.
+func (m *Conversation) receipts() bool {
+	return m.ss.Caps(m.serverID).Has(protocol.CapReceipts)
+}
+
+// markRead tells the others the history was seen up to the last message.
+func (m *Conversation) markRead() tea.Cmd {
+	seq := m.store.LastSeq()
+	if seq == 0 || !m.receipts() {
+		return nil
+	}
+	return func() tea.Msg {
+		m.ss.MarkRead(m.serverID, m.chat, seq)
+		return nil
+	}
+}
+
+func (m *Conversation) render() {
+	msgs := m.store.Messages()
+	lines := make([]string, len(msgs))
+	for i, msg := range msgs {
+		lines[i] = m.renderMessage(msg)
+	}
+
+	m.history.SetContent(lipgloss.NewStyle().
+		Width(m.width).
+		Render(strings.Join(lines, "\n")))
+	if m.follow {
+		m.history.GotoBottom()
+	}
+}
+
+func (m *Conversation) renderMessage(msg chat.Message) string {
+	at := msg.Time()
+	if at.IsZero() {
+		at = time.Now()
+	}
+	stamp := timeStyle.Render(at.Local().Format("15:04"))
+	if msg.System {
+		return stamp + " " + systemStyle.Render(msg.Text)
+	}
+
+	text := msg.Text
+	switch {
+	case msg.Deleted:
+		text = timeStyle.Render("message deleted")
+	case msg.Edited():
+		text += " " + timeStyle.Render("(edited)")
+	}
+
+	line := stamp + " " + authorStyle.Render(msg.Author) + " " + text
+	state, mine := m.states[msg.ID]
+	if !mine {
+		return line
+	}
+
+	if !m.receipts() && state != chat.Failed {
+		state = min(state, chat.Sent)
+	}
+	marker := markerStyle.Render(state.Marker())
+	switch state {
+	case chat.Read:
+		marker = readStyle.Render(state.Marker())
+	case chat.Failed:
+		marker = failedStyle.Render(state.Marker())
+	}
+	return line + " " + marker
+}
+
+func (m *Conversation) View() string {
+	rule := timeStyle.Render(strings.Repeat("─", m.width))
+	return lipgloss.JoinVertical(lipgloss.Left,
+		m.history.View(),
+		rule,
+		m.input.View(),
+	)
+}
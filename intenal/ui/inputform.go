@@ -35,6 +35,7 @@ type inputField struct {
 }
 type InputFormModel struct {
 	action     InputFormAction
+	cancel     func() tea.Cmd
 	prompt     string
 	focusIndex int
 	cursorMode cursor.Mode
@@ -54,6 +55,10 @@ func NewInputFormModel(prompt string, specs []types.InputFieldSpec,
 		t.TextStyle = focusedStyle
 		t.Blur()
 		t.PlaceholderStyle = PlaceholderStyle
+		if f.Secret {
+			t.EchoMode = textinput.EchoPassword
+			t.EchoCharacter = '•'
+		}
 		fields[i] = inputField{
 			InputFieldSpec: f,
 			text:           t,
@@ -68,6 +73,12 @@ func NewInputFormModel(prompt string, specs []types.InputFieldSpec,
 	}
 }
 
+// SetCancelAction sets the action run when the form is closed without
+// submitting.
+func (m *InputFormModel) SetCancelAction(cancel func() tea.Cmd) {
+	m.cancel = cancel
+}
+
 func (m *InputFormModel) Init() tea.Cmd {
 	m.updateFocus()
 	return textinput.Blink
@@ -95,6 +106,9 @@ func (m *InputFormModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case tea.KeyMsg:
 		switch {
 		case key.Matches(msg, keys.Keys.Close):
+			if m.cancel != nil {
+				return m, tea.Sequence(m.cancel(), NewDeleteCmd(m))
+			}
 			return m, NewDeleteCmd(m)
 		case key.Matches(msg, keys.Keys.Up):
 			if m.focusIndex > 0 {
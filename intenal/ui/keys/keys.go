@@ -5,10 +5,14 @@ import "github.com/charmbracelet/bubbles/key"
 type AppKeys struct {
 	Up     key.Binding
 	Down   key.Binding
+	Left   key.Binding
+	Right  key.Binding
 	Quit   key.Binding
 	Next   key.Binding
 	Choose key.Binding
-	Close  key.Binding
+	// actions on the selected item besides choosing it
+	Options key.Binding
+	Close   key.Binding
 }
 
 var Keys = AppKeys{
@@ -20,6 +24,14 @@ var Keys = AppKeys{
 		key.WithKeys("j", "down"),
 		key.WithHelp("↓/j", "move down"),
 	),
+	Left: key.NewBinding(
+		key.WithKeys("h", "left"),
+		key.WithHelp("←/h", "move left"),
+	),
+	Right: key.NewBinding(
+		key.WithKeys("l", "right"),
+		key.WithHelp("→/l", "move right"),
+	),
 	Next: key.NewBinding(
 		key.WithKeys("tab"),
 		key.WithHelp("tab", "next window"),
@@ -32,6 +44,10 @@ var Keys = AppKeys{
 		key.WithKeys("enter", " "),
 		key.WithHelp("enter", " "),
 	),
+	Options: key.NewBinding(
+		key.WithKeys("o"),
+		key.WithHelp("o", "options"),
+	),
 	Close: key.NewBinding(
 		key.WithKeys("esc", "esc"),
 		key.WithHelp("enter", "esc"),
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"andrew_chat/intenal/color"
+	"andrew_chat/intenal/domain"
+	"andrew_chat/intenal/server"
+	"fmt"
+	"io"
+
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// serverDelegate renders servers like the default delegate, with the
+// result of the last reachability probe appended to the description.
+type serverDelegate struct {
+	list.DefaultDelegate
+	ss *server.ServerService
+}
+
+func newServerDelegate(ss *server.ServerService) serverDelegate {
+	return serverDelegate{
+		DefaultDelegate: list.NewDefaultDelegate(),
+		ss:              ss,
+	}
+}
+
+// probedServer overrides the description of a server item.
+type probedServer struct {
+	domain.Server
+	desc string
+}
+
+func (s probedServer) Description() string {
+	return s.desc
+}
+
+func (d serverDelegate) Render(w io.Writer, m list.Model, index int, item list.Item) {
+	srv, ok := item.(domain.Server)
+	if !ok {
+		d.DefaultDelegate.Render(w, m, index, item)
+		return
+	}
+	badge := d.badge(srv.ID)
+	if badge == "" {
+		d.DefaultDelegate.Render(w, m, index, item)
+		return
+	}
+	d.DefaultDelegate.Render(w, m, index, probedServer{
+		Server: srv,
+		desc:   srv.Description() + "  " + badge,
+	})
+}
+
+// badge shows whether the server was up at the last probe and how long the
+// connect took, empty if not probed yet.
+func (d serverDelegate) badge(serverID string) string {
+	p, ok := d.ss.Probe(serverID)
+	if !ok {
+		return ""
+	}
+
+	status, text := "disconnected", "down"
+	if p.Up {
+		status = "connected"
+		text = fmt.Sprintf("up %dms", max(p.Latency.Milliseconds(), 1))
+	}
+	return lipgloss.NewStyle().
+		Foreground(color.GColorScheme.ServerStatus[status].Text).
+		Render("● " + text)
+}
@@ -1,6 +1,8 @@
 package ui
 
 import (
+	"andrew_chat/intenal/ui/types"
+
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 )
@@ -8,6 +10,15 @@ import (
 // implements bubbletea.model
 type TextView struct {
 	viewport viewport.Model
+	content  string
+	// called once the window is closed
+	onClose func()
+}
+
+// streamMsg carries the next line read by Stream.
+type streamMsg struct {
+	line  string
+	lines <-chan string
 }
 
 func NewTextView() *TextView {
@@ -28,6 +39,9 @@ func (m *TextView) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case tea.WindowSizeMsg:
 		m.viewport.Width = msg.Width
 		m.viewport.Height = msg.Height
+	case streamMsg:
+		m.AppendLine(msg.line)
+		return m, m.Stream(msg.lines)
 	}
 
 	m.viewport, cmd = m.viewport.Update(msg)
@@ -40,5 +54,44 @@ func (m *TextView) View() string {
 }
 
 func (m *TextView) SetContent(content string) {
+	m.content = content
 	m.viewport.SetContent(content)
 }
+
+// AppendLine adds line at the end and scrolls to it.
+func (m *TextView) AppendLine(line string) {
+	if m.content != "" {
+		m.content += "\n"
+	}
+	m.SetContent(m.content + line)
+	m.viewport.GotoBottom()
+}
+
+// OnClose sets f to be called when the window of the view is closed, e.g.
+// to stop the producer of a Stream.
+func (m *TextView) OnClose(f func()) {
+	m.onClose = f
+}
+
+// Close implements types.Closer.
+func (m *TextView) Close() tea.Cmd {
+	if m.onClose != nil {
+		m.onClose()
+	}
+	return nil
+}
+
+// Stream appends the lines received from lines until it is closed. The
+// returned command must run after the view is added to the window manager.
+func (m *TextView) Stream(lines <-chan string) tea.Cmd {
+	return func() tea.Msg {
+		line, ok := <-lines
+		if !ok {
+			return nil
+		}
+		return types.WindowMsg{
+			Model: m,
+			Msg:   streamMsg{line: line, lines: lines},
+		}
+	}
+}
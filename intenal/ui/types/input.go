@@ -5,6 +5,8 @@ type InputFieldSpec struct {
 	Title       string
 	Desc        string
 	Placeholder string
+	// masks the typed text
+	Secret bool
 }
 
 type InputFieldValue struct {
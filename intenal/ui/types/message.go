@@ -2,6 +2,9 @@ package types
 
 import (
 	"andrew_chat/intenal/domain"
+	"andrew_chat/intenal/domain/chat"
+	"andrew_chat/intenal/server"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 )
@@ -9,6 +12,28 @@ import (
 type ServerMsg struct {
 	Status int // "connect", "disconnect", "select"
 	Server domain.Server
+	// why the connection failed or was lost, nil otherwise
+	Err error
+	// reconnect attempt and when it starts, zero if not reconnecting
+	Attempt int
+	RetryAt time.Time
+	// round trip of the last heartbeat, zero if unknown
+	Latency time.Duration
+	// the connection waits for a password, nil otherwise
+	Password *server.PasswordRequest
+}
+
+// ChatMsg reports a message that arrived in a chat, or a new delivery
+// state of a sent message.
+type ChatMsg struct {
+	Server string
+	Chat   string
+	// nil for delivery state changes
+	Message *chat.Message
+	ID      string
+	State   chat.DeliveryState
+	// the server rejected the message, nil otherwise
+	Err error
 }
 
 // The message is an instruction to the window manager where to place the window.
@@ -19,11 +44,44 @@ type CreateWindowMsg struct {
 	Focus bool
 }
 
+// WindowMsg is delivered to the window of Model, focused or not. Messages
+// of background work use it to reach the window that started the work.
+type WindowMsg struct {
+	Model tea.Model
+	Msg   tea.Msg
+}
+
+// BroadcastMsg delivers Msg to every window, focused or not.
+type BroadcastMsg struct {
+	Msg tea.Msg
+}
+
+// Closer is implemented by windows that need to clean up when they are
+// closed. The window manager calls Close once the window is gone.
+type Closer interface {
+	Close() tea.Cmd
+}
+
 // The message is an instruction to the window manager to delete the window.
 type DeleteWindowMsg struct {
 	Model tea.Model
 }
 
+// OpenChatMsg asks to open the conversation of Chat on Server.
+type OpenChatMsg struct {
+	Server string
+	Chat   chat.Chat
+}
+
+// ChatClosedMsg reports that the conversation of Chat on Server was closed.
+type ChatClosedMsg struct {
+	Server string
+	Chat   string
+}
+
+// RefreshMsg asks the focused window to reload its content (F5).
+type RefreshMsg struct{}
+
 type FilterMsg struct {
 	Filter string
 }
@@ -15,6 +15,8 @@ const (
 	PositionTopRight Position = PositionRight | PositionTop
 	PositionBotRight Position = PositionRight | PositionBot
 	PositionSentinel Position = -1
+	// lets the window manager pick a position without a window
+	PositionFree Position = 0
 )
 
 func (p Position) IsTop() bool {
@@ -1,7 +1,9 @@
 package ui
 
 import (
+	"andrew_chat/intenal/protocol"
 	"andrew_chat/intenal/ui/types"
+	"errors"
 
 	tea "github.com/charmbracelet/bubbletea"
 )
@@ -31,3 +33,13 @@ func NewErrCmd(text string) tea.Cmd {
 		}
 	}
 }
+
+// NewRequestErrCmd reports a failed request: what is the action that failed,
+// a rejection by the server is shown by its text alone.
+func NewRequestErrCmd(what string, err error) tea.Cmd {
+	var e protocol.Error
+	if errors.As(err, &e) {
+		return NewErrCmd(what + ": " + e.Text)
+	}
+	return NewErrCmd(what + " failed: " + err.Error())
+}
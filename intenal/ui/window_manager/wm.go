@@ -80,7 +80,9 @@ func (wm *WindowManager) getWindowHeightWithoutBorder(p types.Position) int {
 	return totalAvailable / count
 }
 
-func (wm *WindowManager) closeWindow(win tea.Model) {
+// closeWindow removes win. It returns the command of Close if win is a
+// types.Closer.
+func (wm *WindowManager) closeWindow(win tea.Model) tea.Cmd {
 	debug.DebugDump(debug.V, "Remove get", win)
 	p := types.PositionSentinel
 	for pos, w := range wm.windows {
@@ -117,12 +119,23 @@ func (wm *WindowManager) closeWindow(win tea.Model) {
 	}
 
 	wm.updateWindows()
+	if c, ok := win.(types.Closer); ok {
+		return c.Close()
+	}
+	return nil
 }
 
-func (wm *WindowManager) addWindow(p types.Position, win tea.Model, focus bool) {
+// addWindow places win at p, the window there before is closed. It returns
+// the command of closing it.
+func (wm *WindowManager) addWindow(p types.Position, win tea.Model, focus bool) tea.Cmd {
 	if win == nil {
 		panic("set nil window not allowed")
 	}
+	// a replaced window must leave the stack as well
+	var cmd tea.Cmd
+	if old := wm.getWindow(p); old != nil {
+		cmd = wm.closeWindow(old)
+	}
 
 	wm.windows[p] = win
 
@@ -139,9 +152,27 @@ func (wm *WindowManager) addWindow(p types.Position, win tea.Model, focus bool)
 		}
 	}
 
-	debug.DebugDump(debug.V, fmt.Sprintf("Add window pos: %d, focus: %d", p, focus), win)
+	debug.DebugDump(debug.V, fmt.Sprintf("Add window pos: %d, focus: %t", p, focus), win)
 	win.Init()
 	wm.updateWindows()
+	return cmd
+}
+
+// freePosition returns the first position without a window, right side
+// first. If all are taken, the bottom right window makes room.
+func (wm *WindowManager) freePosition() (types.Position, tea.Cmd) {
+	order := []types.Position{
+		types.PositionBotRight,
+		types.PositionTopRight,
+		types.PositionBotLeft,
+		types.PositionTopLeft,
+	}
+	for _, p := range order {
+		if wm.getWindow(p) == nil {
+			return p, nil
+		}
+	}
+	return types.PositionBotRight, wm.closeWindow(wm.windows[types.PositionBotRight])
 }
 
 func (wm *WindowManager) getWindow(p types.Position) tea.Model {
@@ -237,7 +268,7 @@ func (m *WindowManager) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, nil
 		case msg.String() == "ctrl+d":
 			if m.focus != notFocused {
-				m.closeWindow(m.stack[len(m.stack)-1].model)
+				return m, m.closeWindow(m.stack[len(m.stack)-1].model)
 			}
 			return m, nil
 		default:
@@ -259,11 +290,31 @@ func (m *WindowManager) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.updateWindows()
 
 	case types.CreateWindowMsg:
-		m.addWindow(msg.Pos, msg.Model, msg.Focus)
-		return m, msg.Model.Init()
+		pos := msg.Pos
+		var closed tea.Cmd
+		if pos == types.PositionFree {
+			pos, closed = m.freePosition()
+		}
+		replaced := m.addWindow(pos, msg.Model, msg.Focus)
+		return m, tea.Batch(closed, replaced, msg.Model.Init())
+	case types.WindowMsg:
+		for _, w := range m.windows {
+			if w == msg.Model {
+				_, cmd := w.Update(msg.Msg)
+				return m, cmd
+			}
+		}
+		// the window is closed, nobody waits for the message
+	case types.BroadcastMsg:
+		var cmds []tea.Cmd
+		for _, w := range m.windows {
+			_, cmd := w.Update(msg.Msg)
+			cmds = append(cmds, cmd)
+		}
+		return m, tea.Batch(cmds...)
 	case types.DeleteWindowMsg:
 		debug.DebugDump(debug.V, "WM DeleteWindowMsg", msg)
-		m.closeWindow(msg.Model)
+		return m, m.closeWindow(msg.Model)
 
 	default:
 		if m.focus != notFocused {
@@ -0,0 +1,141 @@
+package wsconn
+
+import (
+	"andrew_chat/intenal/protocol"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// pair connects a client Conn to a test server whose side of the
+// connection is handled by serve.
+func pair(t *testing.T, serve func(ws *websocket.Conn)) *Conn {
+	t.Helper()
+	upgrader := websocket.Upgrader{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		serve(ws)
+	}))
+	t.Cleanup(ts.Close)
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := New(ws)
+	t.Cleanup(func() { c.Close() })
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	return c
+}
+
+func TestFrames(t *testing.T) {
+	// the server decodes the frames and sends them back
+	c := pair(t, func(ws *websocket.Conn) {
+		conn := New(ws)
+		dec := protocol.NewDecoder(conn)
+		enc := protocol.NewEncoder(conn)
+		for {
+			f, err := dec.Decode()
+			if err != nil {
+				return
+			}
+			if err := enc.Encode(f); err != nil {
+				return
+			}
+		}
+	})
+
+	enc := protocol.NewEncoder(c)
+	dec := protocol.NewDecoder(c)
+	texts := []string{"hi", strings.Repeat("long ", 10000), ""}
+	for i, text := range texts {
+		f, err := protocol.NewFrame(protocol.TypeMessage, protocol.Message{ID: string(rune('a' + i)), Text: text})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.Encode(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, text := range texts {
+		f, err := dec.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var msg protocol.Message
+		if err := f.Decode(&msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.ID != string(rune('a'+i)) || msg.Text != text {
+			t.Errorf("frame %d: %q with %d bytes, want %d", i, msg.ID, len(msg.Text), len(text))
+		}
+	}
+}
+
+func TestWriteIsOneMessage(t *testing.T) {
+	got := make(chan string, 1)
+	c := pair(t, func(ws *websocket.Conn) {
+		mt, data, err := ws.ReadMessage()
+		if err != nil || mt != websocket.BinaryMessage {
+			got <- ""
+			return
+		}
+		got <- string(data)
+	})
+
+	if _, err := c.Write([]byte("one frame")); err != nil {
+		t.Fatal(err)
+	}
+	if s := <-got; s != "one frame" {
+		t.Errorf("server read %q", s)
+	}
+}
+
+func TestPartialReads(t *testing.T) {
+	c := pair(t, func(ws *websocket.Conn) {
+		ws.WriteMessage(websocket.BinaryMessage, []byte("hello"))
+		ws.WriteMessage(websocket.BinaryMessage, []byte{})
+		ws.WriteMessage(websocket.BinaryMessage, []byte("world!"))
+		msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+		ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	})
+
+	// reads smaller than the messages, and ones spanning their ends
+	var got []byte
+	buf := make([]byte, 4)
+	for {
+		n, err := c.Read(buf)
+		got = append(got, buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			t.Fatal("empty read")
+		}
+	}
+	if string(got) != "helloworld!" {
+		t.Errorf("read %q", got)
+	}
+}
+
+func TestTextMessage(t *testing.T) {
+	c := pair(t, func(ws *websocket.Conn) {
+		ws.WriteMessage(websocket.TextMessage, []byte("hello"))
+	})
+
+	if _, err := c.Read(make([]byte, 16)); err != errTextMessage {
+		t.Errorf("read a text message: %v", err)
+	}
+}